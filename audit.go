@@ -0,0 +1,32 @@
+package main
+
+const (
+	auditCommand = "audit"
+
+	findingHandEdited = "hand-edited"
+)
+
+var (
+	// auditMode is set by `pbsync audit`, which reports generated files
+	// that were edited by hand instead of updating them.
+	auditMode bool
+)
+
+// auditFile reports dest, which should contain the generated contents b of
+// protoFile, if it was edited by hand: if its contents destContents match
+// neither b nor what pbsync last synced to it.
+func auditFile(protoFile, dest string, b, destContents []byte, destExists bool, result *result) {
+	if !destExists || result.config.sameContents(b, destContents) {
+		return
+	}
+	entry := result.manifestEntry(dest)
+	if entry == nil {
+		// Without a record of what was synced, an outdated file can't be
+		// told apart from an edited one.
+		debugf("%s: not auditing %s, which pbsync didn't sync before", protoFile, dest)
+		return
+	}
+	if entry.Hash != result.config.comparisonHash(destContents) {
+		result.addFinding(findingHandEdited, dest, protoFile, "edited since it was last synced; run pbsync to restore the file generated from %s", protoFile)
+	}
+}
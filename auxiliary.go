@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// auxiliaryOutputs returns the files generated next to the outputs found
+// for protoFile and named after it with one of the given suffixes, such as
+// the foo.pb.json.go that protoc-gen-go-json emits next to foo.pb.go. They
+// are synced to the directories of the outputs they were found next to.
+func auxiliaryOutputs(protoFile string, found []srcAndDest, suffixes []string) []srcAndDest {
+	base := strings.TrimSuffix(filepath.Base(protoFile), ".proto")
+	seen := map[string]bool{}
+	for _, f := range found {
+		seen[f.src] = true
+	}
+	var res []srcAndDest
+	for _, f := range found {
+		for _, suffix := range suffixes {
+			src := filepath.Join(filepath.Dir(f.src), base+suffix)
+			if seen[src] {
+				continue
+			}
+			seen[src] = true
+			if _, err := statGenerated(src); err != nil {
+				continue
+			}
+			res = append(res, srcAndDest{src: src, dest: filepath.Join(filepath.Dir(f.dest), base+suffix)})
+		}
+	}
+	return res
+}
+
+func validateAuxiliarySuffixes(suffixes map[string][]string) error {
+	for kind, list := range suffixes {
+		if !isSupportedKind(kind) {
+			return fmt.Errorf("auxiliary_suffixes: unsupported rule kind %q", kind)
+		}
+		if len(list) == 0 {
+			return fmt.Errorf("auxiliary_suffixes: no suffixes listed for %s", kind)
+		}
+		for _, s := range list {
+			if s == "" || strings.ContainsAny(s, `/\`) {
+				return fmt.Errorf("auxiliary_suffixes: invalid suffix %q for %s", s, kind)
+			}
+		}
+	}
+	return nil
+}
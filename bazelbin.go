@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// outputBaseMarker is the file bazel writes to the root of an output
+	// base, containing the path of the workspace it belongs to.
+	outputBaseMarker = "DO_NOT_BUILD_HERE"
+)
+
+// symlinkBazelBin returns the target of the workspace's bazel-bin
+// convenience symlink, if it can be trusted: the target must exist and be
+// inside the output base of this workspace. The symlink can be left
+// dangling by `bazel clean --expunge`, or point at the output base of
+// another checkout if it was copied along with the workspace.
+func symlinkBazelBin(workspaceRoot string) (string, error) {
+	target, err := filepath.EvalSymlinks(filepath.Join(workspaceRoot, "bazel-bin"))
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(fsPath(target))
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", target)
+	}
+	outputBase := findOutputBase(target)
+	if outputBase == "" {
+		return "", fmt.Errorf("%s is not inside a bazel output base", target)
+	}
+	b, err := os.ReadFile(fsPath(filepath.Join(outputBase, outputBaseMarker)))
+	if err != nil {
+		return "", err
+	}
+	owner := strings.TrimSpace(string(b))
+	if !sameDir(owner, workspaceRoot) {
+		return "", fmt.Errorf("output base %s belongs to workspace %s", outputBase, owner)
+	}
+	return target, nil
+}
+
+// findOutputBase returns the output base containing the bazel output
+// directory dir (<output base>/execroot/<workspace>/bazel-out/...), or "".
+func findOutputBase(dir string) string {
+	for d := dir; ; {
+		parent := filepath.Dir(d)
+		if parent == d {
+			return ""
+		}
+		if filepath.Base(d) == "execroot" {
+			return parent
+		}
+		d = parent
+	}
+}
+
+// sameDir returns whether the paths a and b refer to the same directory.
+func sameDir(a, b string) bool {
+	ai, err := os.Stat(fsPath(a))
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(fsPath(b))
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
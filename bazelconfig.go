@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	bazelConfigs = flag.String("bazel-config", "", "Comma-separated bazel --config values that outputs are built with, passed on to `bazel info`.")
+
+	// bazelConfigFiles are the workspace-relative files whose contents
+	// affect bazel's outputs.
+	bazelConfigFiles = []string{".bazelrc", "user.bazelrc", ".bazelversion", ".bazeliskrc"}
+
+	fingerprintMu sync.Mutex
+	fingerprints  = map[string]string{}
+)
+
+// bazelConfigArgs returns the --config arguments for bazel commands.
+func bazelConfigArgs() []string {
+	var args []string
+	for _, c := range strings.Split(*bazelConfigs, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			args = append(args, "--config="+c)
+		}
+	}
+	return args
+}
+
+// bazelConfigFingerprint returns a hash of the bazel configuration of the
+// workspace: its rc and version files, the user's ~/.bazelrc, the bazel
+// version selected by the environment, and --bazel-config. Cached values
+// derived from bazel's outputs are only valid for the fingerprint they were
+// computed with.
+func bazelConfigFingerprint(workspaceRoot string) string {
+	fingerprintMu.Lock()
+	defer fingerprintMu.Unlock()
+	if fp, ok := fingerprints[workspaceRoot]; ok {
+		return fp
+	}
+	var paths []string
+	for _, f := range bazelConfigFiles {
+		paths = append(paths, filepath.Join(workspaceRoot, f))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".bazelrc"))
+	}
+	h := sha256.New()
+	for _, path := range paths {
+		// Missing files hash the same as empty ones, which bazel treats
+		// the same way.
+		b, _ := os.ReadFile(fsPath(path))
+		fmt.Fprintf(h, "%s\x00%x\x00", path, sha256.Sum256(b))
+	}
+	fmt.Fprintf(h, "USE_BAZEL_VERSION=%s\x00", os.Getenv("USE_BAZEL_VERSION"))
+	fmt.Fprintf(h, "config=%s\x00", strings.Join(bazelConfigArgs(), " "))
+	fp := fmt.Sprintf("%x", h.Sum(nil))
+	fingerprints[workspaceRoot] = fp
+	return fp
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	bazelLockPollInterval = 500 * time.Millisecond
+)
+
+var (
+	waitForBazel = flag.Duration("wait-for-bazel", 0, "If a bazel command is running in the workspace, wait up to this long for it to finish before reading its outputs, which may be half-written. With 0, only warn.")
+)
+
+// awaitBazel checks whether a bazel command is running in the workspace, by
+// testing the lock bazel holds on its output base while running a command,
+// and waits for it to finish if --wait-for-bazel is set.
+func awaitBazel(ctx context.Context, workspaceRoot string) error {
+	if *bazelBinFlag != "" {
+		// Outputs were given explicitly and may not come from a local
+		// bazel server at all.
+		return nil
+	}
+	bazelBin, err := getBazelBinDir(workspaceRoot)
+	if err != nil {
+		return nil
+	}
+	if resolved, err := filepath.EvalSymlinks(bazelBin); err == nil {
+		bazelBin = resolved
+	}
+	outputBase := findOutputBase(bazelBin)
+	if outputBase == "" {
+		return nil
+	}
+	lockPath := filepath.Join(outputBase, "lock")
+	deadline := time.Now().Add(*waitForBazel)
+	for waited := false; ; waited = true {
+		locked, err := isLocked(fsPath(lockPath))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				debugf("%s: failed to check bazel lock: %s", workspaceRoot, err)
+			}
+			return nil
+		}
+		if !locked {
+			if waited {
+				debugf("%s: bazel command finished", workspaceRoot)
+			}
+			return nil
+		}
+		if *waitForBazel == 0 {
+			warnf("%s: a bazel command is running; its outputs may be incomplete (use --wait-for-bazel to wait for it)", workspaceRoot)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for the running bazel command to finish", *waitForBazel)
+		}
+		if !waited {
+			printf("pbsync: %s: waiting for the running bazel command to finish\n", workspaceRoot)
+		}
+		if err := sleepContext(ctx, bazelLockPollInterval); err != nil {
+			return err
+		}
+	}
+}
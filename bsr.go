@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	bsrPushCommand = "bsr push"
+	bsrPullCommand = "bsr pull"
+
+	bufModuleConfig = "buf.yaml"
+)
+
+var (
+	bufModuleNameRe = regexp.MustCompile(`(?m)^name:\s*["']?([^\s"'#]+)`)
+)
+
+// bsrModule is a Buf Schema Registry module defined in the workspace.
+type bsrModule struct {
+	// name is the module's BSR name, e.g. "buf.build/acme/apis".
+	name string
+	// dir is the module root (the directory containing buf.yaml).
+	dir string
+}
+
+// findBSRModules returns the named buf modules in the workspace which own at
+// least one of the given protos (or any proto, if protoList is nil) that is
+// a src of a proto_library rule.
+func findBSRModules(workspaceRoot string, protoList []string) ([]*bsrModule, error) {
+	configs, err := gitListFiles(workspaceRoot, "*"+bufModuleConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buf modules: %s", err)
+	}
+	var modules []*bsrModule
+	for _, config := range configs {
+		if filepath.Base(config) != bufModuleConfig {
+			continue
+		}
+		path := filepath.Join(workspaceRoot, config)
+		b, err := os.ReadFile(fsPath(path))
+		if err != nil {
+			return nil, err
+		}
+		m := bufModuleNameRe.FindSubmatch(b)
+		if m == nil {
+			// Modules without a name can't be pushed to or pulled from the
+			// BSR.
+			continue
+		}
+		modules = append(modules, &bsrModule{name: string(m[1]), dir: filepath.Dir(path)})
+	}
+	if len(modules) == 0 {
+		return nil, nil
+	}
+
+	protos, err := resolveProtos(workspaceRoot, protoList)
+	if err != nil {
+		return nil, err
+	}
+	buildFiles := newBuildFileIndex(workspaceRoot)
+	owned := map[*bsrModule]bool{}
+	for _, proto := range protos {
+		buildFile, err := buildFiles.find(proto)
+		if err != nil {
+			continue
+		}
+		if _, ok := buildFile.protoFileToRule[protoKey(workspaceRoot, proto)]; !ok {
+			continue
+		}
+		if m := owningModule(modules, proto); m != nil {
+			owned[m] = true
+		}
+	}
+	var res []*bsrModule
+	for _, m := range modules {
+		if owned[m] {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// owningModule returns the innermost module containing path.
+func owningModule(modules []*bsrModule, path string) *bsrModule {
+	var owner *bsrModule
+	for _, m := range modules {
+		if !strings.HasPrefix(path, m.dir+string(filepath.Separator)) {
+			continue
+		}
+		if owner == nil || len(m.dir) > len(owner.dir) {
+			owner = m
+		}
+	}
+	return owner
+}
+
+// bsrPush pushes the BSR modules owning the workspace's proto_library rules.
+func bsrPush(workspaceRoot string, protoList []string) error {
+	modules, err := findBSRModules(workspaceRoot, protoList)
+	if err != nil {
+		return err
+	}
+	for _, m := range modules {
+		cmd := exec.Command("buf", "push")
+		cmd.Dir = m.dir
+		if b, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("buf push %s failed: %s\n%s", m.name, err, b)
+		}
+		printf("pbsync: pushed %s\n", m.name)
+	}
+	return nil
+}
+
+// bsrPull generates code for the BSR modules owning the workspace's
+// proto_library rules from the registry, using the workspace's buf.gen.yaml
+// (typically with remote plugins), and syncs it into the workspace.
+func bsrPull(ctx context.Context, workspaceRoot string, protoList []string) (*result, error) {
+	if _, err := os.Stat(fsPath(filepath.Join(workspaceRoot, bufGenTemplate))); err != nil {
+		return nil, fmt.Errorf("cannot pull from the BSR: %s", err)
+	}
+	modules, err := findBSRModules(workspaceRoot, protoList)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockWorkspace(workspaceRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock workspace: %s", err)
+	}
+	defer unlock()
+
+	cfg, err := loadConfig(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	outputRoot, err := cfg.outputRoot(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	result := &result{config: cfg, workspaceRoot: workspaceRoot, outputRoot: outputRoot}
+	result.manifest, err = loadManifest(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range modules {
+		if err := ctx.Err(); err != nil {
+			// Record the files synced before the interruption.
+			if err := saveManifest(workspaceRoot, result.manifest); err != nil {
+				debugf("failed to save manifest: %s", err)
+			}
+			return result, err
+		}
+		if err := bsrPullModule(workspaceRoot, m, result); err != nil {
+			return nil, err
+		}
+	}
+	if err := saveManifest(workspaceRoot, result.manifest); err != nil {
+		return nil, fmt.Errorf("failed to save manifest: %s", err)
+	}
+	return result, nil
+}
+
+func bsrPullModule(workspaceRoot string, m *bsrModule, result *result) error {
+	outDir, err := os.MkdirTemp("", "pbsync-bsr-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	cmd := exec.Command("buf", "generate", m.name, "--template", bufGenTemplate, "--output", outDir)
+	cmd.Dir = workspaceRoot
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("buf generate %s failed: %s\n%s", m.name, err, b)
+	}
+	return syncGeneratedDir(workspaceRoot, outDir, result)
+}
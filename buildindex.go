@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// buildFileIndex finds the BUILD file declaring the proto_library rule for
+// a proto. That is usually the BUILD file of the proto's own package, but
+// proto_library srcs can also reference protos in other packages, e.g.
+// "//shared:common.proto".
+type buildFileIndex struct {
+	workspaceRoot string
+	parser        *buildFileParser
+
+	once sync.Once
+	// owners maps the keys (see protoKey) of protos referenced from other
+	// packages to the BUILD files referencing them.
+	owners map[string]string
+	err    error
+}
+
+func newBuildFileIndex(workspaceRoot string) *buildFileIndex {
+	return &buildFileIndex{
+		workspaceRoot: workspaceRoot,
+		parser:        newBuildFileParser(workspaceRoot),
+	}
+}
+
+// find returns the parsed BUILD file declaring the rule for protoFile. If no
+// BUILD file declares it, the BUILD file of the proto's package is returned,
+// or an error satisfying os.IsNotExist if there is none.
+func (x *buildFileIndex) find(protoFile string) (*parsedBuildFile, error) {
+	// For now only support build files named "BUILD".
+	buildFilePath := filepath.Join(filepath.Dir(protoFile), "BUILD")
+	buildFile, err := x.parser.Parse(buildFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to parse BUILD file at %q: %v", buildFilePath, err)
+	}
+	key := protoKey(x.workspaceRoot, protoFile)
+	if err == nil {
+		if _, ok := buildFile.protoFileToRule[key]; ok {
+			return buildFile, nil
+		}
+	}
+
+	x.once.Do(x.index)
+	if x.err != nil {
+		return nil, x.err
+	}
+	owner, ok := x.owners[key]
+	if !ok {
+		return buildFile, err
+	}
+	debugf("%s: proto rule is in %s", protoFile, owner)
+	return x.parser.Parse(owner)
+}
+
+// index finds the protos referenced by proto_library rules in other
+// packages, by parsing all BUILD files in the workspace.
+func (x *buildFileIndex) index() {
+	x.owners = map[string]string{}
+	var paths []string
+	for _, pathspec := range []string{"BUILD", "*/BUILD"} {
+		p, err := gitListFiles(x.workspaceRoot, pathspec)
+		if err != nil {
+			x.err = err
+			return
+		}
+		paths = append(paths, p...)
+	}
+	for _, p := range paths {
+		buildFilePath := filepath.Join(x.workspaceRoot, p)
+		buildFile, err := x.parser.Parse(buildFilePath)
+		if err != nil {
+			// Unrelated BUILD files may use syntax we don't understand.
+			debugf("%s: skipping BUILD file: %s", buildFilePath, err)
+			continue
+		}
+		pkg := path.Dir(filepath.ToSlash(p))
+		for key := range buildFile.protoFileToRule {
+			if path.Dir(key) != pkg {
+				x.owners[key] = buildFilePath
+			}
+		}
+	}
+}
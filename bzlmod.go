@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// repoMappingMaxDepth is how deep in bazel-bin to look for a repo mapping
+// manifest.
+const repoMappingMaxDepth = 4
+
+// externalRepos resolves the directories of external repositories in
+// bazel-bin. Under bzlmod, they are named after the repositories'
+// canonical names (e.g. "rules_go~" or "gazelle++go_deps+com_github_x"),
+// rather than the apparent names used in labels.
+type externalRepos struct {
+	workspaceRoot, bazelBin string
+
+	// mapping maps apparent repo names to canonical names, once loaded.
+	mapping map[string]string
+}
+
+func newExternalRepos(workspaceRoot, bazelBin string) *externalRepos {
+	return &externalRepos{workspaceRoot: workspaceRoot, bazelBin: bazelBin}
+}
+
+// dir returns the bazel-bin directory of the repository named by l, whose
+// label is s.
+func (e *externalRepos) dir(s string, l *label) string {
+	dir := filepath.Join(e.bazelBin, "external", l.repo)
+	// "@@repo" labels already use canonical names.
+	if strings.HasPrefix(s, "@@") || !isBzlmod(e.workspaceRoot) {
+		return dir
+	}
+	if _, err := os.Stat(fsPath(dir)); err == nil {
+		return dir
+	}
+	if e.mapping == nil {
+		m, err := loadRepoMapping(e.workspaceRoot, e.bazelBin)
+		if err != nil {
+			warnf("could not resolve canonical repository names: %s", err)
+			m = map[string]string{}
+		}
+		e.mapping = m
+	}
+	if canonical, ok := e.mapping[l.repo]; ok {
+		debugf("resolved repository @%s to @@%s", l.repo, canonical)
+		return filepath.Join(e.bazelBin, "external", canonical)
+	}
+	return dir
+}
+
+// isBzlmod returns whether the workspace uses bzlmod.
+func isBzlmod(workspaceRoot string) bool {
+	_, err := os.Stat(fsPath(filepath.Join(workspaceRoot, "MODULE.bazel")))
+	return err == nil
+}
+
+// loadRepoMapping returns the main repository's mapping of apparent to
+// canonical repository names, from `bazel mod dump_repo_mapping` (bazel
+// 7.1+), or else from a repo mapping manifest written next to a binary
+// built in bazel-bin.
+func loadRepoMapping(workspaceRoot, bazelBin string) (map[string]string, error) {
+	m, err := dumpRepoMapping(workspaceRoot)
+	if err == nil {
+		return m, nil
+	}
+	debugf("bazel mod dump_repo_mapping failed: %s", err)
+	path, err := findRepoMappingManifest(bazelBin)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("`bazel mod dump_repo_mapping` failed (it requires bazel 7.1 or higher) and no repo mapping manifest was found in %s", bazelBin)
+	}
+	debugf("reading repo mapping manifest %s", path)
+	return readRepoMappingManifest(path)
+}
+
+func dumpRepoMapping(workspaceRoot string) (map[string]string, error) {
+	args := append([]string{"mod", "dump_repo_mapping"}, bazelConfigArgs()...)
+	cmd := exec.Command("bazel", append(args, "")...)
+	cmd.Dir = workspaceRoot
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	m := map[string]string{}
+	if err := json.Unmarshal(out, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse repo mapping: %s", err)
+	}
+	return m, nil
+}
+
+var errRepoMappingFound = errors.New("found")
+
+// findRepoMappingManifest returns the path of a repo mapping manifest in
+// bazel-bin (a "<binary>.repo_mapping" file or a runfiles "_repo_mapping"
+// file), or "" if there is none.
+func findRepoMappingManifest(bazelBin string) (string, error) {
+	root := fsPath(bazelBin)
+	found := ""
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			if rel == "external" || strings.Count(rel, string(filepath.Separator)) >= repoMappingMaxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == "_repo_mapping" || strings.HasSuffix(d.Name(), ".repo_mapping") {
+			found = path
+			return errRepoMappingFound
+		}
+		return nil
+	})
+	if err != nil && err != errRepoMappingFound {
+		return "", err
+	}
+	return found, nil
+}
+
+// readRepoMappingManifest reads the main repository's mappings from a repo
+// mapping manifest, whose lines are
+// "<source canonical name>,<apparent name>,<target canonical name>".
+func readRepoMappingManifest(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := map[string]string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ",")
+		if len(fields) != 3 || fields[0] != "" {
+			continue
+		}
+		m[fields[1]] = fields[2]
+	}
+	return m, s.Err()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// interruptContext returns a context that is canceled when pbsync is
+// interrupted (Ctrl-C or SIGTERM), and a function releasing it. Syncs stop
+// at the next file once it is canceled, finishing in-progress writes so that
+// no partial or temporary files are left behind. A second interrupt exits
+// immediately.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			// Restore the default behavior for a second interrupt.
+			signal.Stop(signals)
+			printf("\npbsync: interrupted; finishing in-progress writes (interrupt again to exit immediately)\n")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// sleepContext sleeps for d, or until ctx is canceled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maxInterruptedFiles is the number of updated files listed per workspace
+// after an interruption.
+const maxInterruptedFiles = 10
+
+// reportInterrupted prints what was synced in each workspace before pbsync
+// was interrupted, and exits.
+func reportInterrupted(workspaces []*workspaceResult) {
+	total := 0
+	for _, ws := range workspaces {
+		if ws.result == nil {
+			printf("pbsync: %s: not synced\n", ws.dir)
+			continue
+		}
+		updated := ws.result.updated
+		total += len(updated)
+		if ws.err == nil {
+			printf("pbsync: %s: completed; updated: %d, up to date: %d\n", ws.dir, ws.result.created, ws.result.upToDate)
+		} else {
+			printf("pbsync: %s: interrupted after updating %d file(s)\n", ws.dir, len(updated))
+		}
+		sort.Strings(updated)
+		for i, dest := range updated {
+			switch {
+			case i < maxInterruptedFiles:
+				printf("  %s\n", dest)
+			case i == maxInterruptedFiles:
+				printf("  ... and %d more\n", len(updated)-i)
+				fallthrough
+			default:
+				debugf("  %s", dest)
+			}
+		}
+	}
+	fatalf("interrupted; %d file(s) were updated", total)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	checkCommand = "check"
+)
+
+var (
+	// checkMode is set by `pbsync check`, which reports destinations that
+	// are out of date instead of updating them.
+	checkMode bool
+)
+
+// Kinds of check mode findings.
+const (
+	findingOutOfDate    = "out-of-date"
+	findingStaleOutput  = "stale-output"
+	findingNotGenerated = "not-generated"
+)
+
+// finding is a problem reported by check mode.
+type finding struct {
+	// kind is the kind of problem, e.g. findingOutOfDate.
+	kind string
+	// path is the destination file (or proto) the finding is about.
+	path string
+	// proto is the proto the destination is generated from.
+	proto string
+	// message describes the problem.
+	message string
+}
+
+func (r *result) addFinding(kind, path, proto, msg string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.findings = append(r.findings, finding{kind: kind, path: path, proto: proto, message: fmt.Sprintf(msg, args...)})
+}
+
+// checkFile reports problems with dest, which should contain the generated
+// contents b of protoFile, and currently contains destContents (if it
+// exists).
+func checkFile(protoFile, dest string, b, destContents []byte, destExists bool, result *result) {
+	// If the generated file is the same one we last synced, but the proto
+	// has changed since then, bazel hasn't regenerated it yet.
+	if entry := result.manifestEntry(dest); entry != nil && entry.Hash == result.config.comparisonHash(b) {
+		if h := result.protoHash(protoFile); h != "" && entry.ProtoHash != "" && h != entry.ProtoHash {
+			result.addFinding(findingStaleOutput, dest, protoFile, "generated from an older version of %s; rebuild needed", protoFile)
+			return
+		}
+	}
+	if !destExists || !result.config.sameContents(b, destContents) {
+		result.addFinding(findingOutOfDate, dest, protoFile, "out of date with the generated file; run pbsync")
+	}
+}
+
+// checkMissing reports protos that have no generated files to compare
+// against.
+func checkMissing(result *result) {
+	for _, proto := range result.missing {
+		result.addFinding(findingNotGenerated, proto, proto, "no generated files found; build its proto rules")
+	}
+}
+
+// printFindings prints the findings and returns how many there were.
+func printFindings(findings []finding) int {
+	for _, f := range findings {
+		printf("%s: %s\n", f.path, f.message)
+	}
+	return len(findings)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as an APFS clone of src.
+func cloneFile(src, dst string) error {
+	if err := unix.Clonefile(src, dst, 0); err != nil {
+		return err
+	}
+	// Clones inherit the source's mode, and Bazel outputs are read-only.
+	if err := os.Chmod(dst, 0644); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package main
+
+func cloneFile(src, dst string) error {
+	return errCloneUnsupported
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	commitCommand = "commit"
+)
+
+// commitGenerated stages the files updated by the sync of a workspace and
+// commits them (and nothing else) with a message listing the protos and
+// rules they were generated from.
+func commitGenerated(workspaceRoot string, result *result) error {
+	if len(result.updated) == 0 {
+		printf("pbsync: %s: nothing to commit\n", workspaceRoot)
+		return nil
+	}
+	rules := map[string]string{}
+	for _, a := range result.actions {
+		rules[a.dest] = a.rule
+	}
+	var files []string
+	protoSet := map[string]bool{}
+	ruleSet := map[string]bool{}
+	// Generated files may be written to a separate worktree.
+	root := result.destRoot()
+	for _, dest := range result.updated {
+		rel, err := filepath.Rel(root, dest)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		if rule, ok := rules[dest]; ok {
+			ruleSet[rule] = true
+		}
+		if e := result.manifestEntry(dest); e != nil && strings.HasSuffix(e.Proto, ".proto") {
+			protoSet[e.Proto] = true
+		}
+	}
+	sort.Strings(files)
+	msg := commitMessage(workspaceRoot, protoSet, ruleSet)
+
+	add := exec.Command("git", append([]string{"add", "--"}, files...)...)
+	add.Dir = root
+	if b, err := add.CombinedOutput(); err != nil {
+		return fmt.Errorf("git add failed: %s\n%s", err, b)
+	}
+	// Passing the paths commits only them, leaving any other staged changes
+	// out of the commit.
+	commit := exec.Command("git", append([]string{"commit", "-m", msg, "--"}, files...)...)
+	commit.Dir = root
+	if b, err := commit.CombinedOutput(); err != nil {
+		return fmt.Errorf("git commit failed: %s\n%s", err, b)
+	}
+	printf("pbsync: %s: committed %d generated file(s)\n", workspaceRoot, len(files))
+	return nil
+}
+
+// commitMessage returns the message for a commit of generated files.
+func commitMessage(workspaceRoot string, protos, rules map[string]bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Update generated proto code\n")
+	writeSection := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		sort.Strings(items)
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		for _, item := range items {
+			fmt.Fprintf(&b, "  %s\n", item)
+		}
+	}
+	var protoList []string
+	for p := range protos {
+		if rel, err := filepath.Rel(workspaceRoot, p); err == nil {
+			p = filepath.ToSlash(rel)
+		}
+		protoList = append(protoList, p)
+	}
+	var ruleList []string
+	for r := range rules {
+		ruleList = append(ruleList, r)
+	}
+	writeSection("Protos", protoList)
+	writeSection("Rules", ruleList)
+	return b.String()
+}
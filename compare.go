@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Policies for deciding whether destinations are up to date.
+const (
+	// compareExact requires destinations to be identical to the generated
+	// files.
+	compareExact = "exact"
+	// compareFinalNewline ignores differences in the newlines at the end
+	// of the files.
+	compareFinalNewline = "final-newline"
+	// compareTrailingWhitespace also ignores whitespace at the end of
+	// lines.
+	compareTrailingWhitespace = "trailing-whitespace"
+)
+
+// comparePolicy returns how destinations are compared to the generated
+// files.
+func (c *config) comparePolicy() string {
+	if c == nil || c.Compare == "" {
+		return compareExact
+	}
+	return c.Compare
+}
+
+func validateComparePolicy(p string) error {
+	switch p {
+	case "", compareExact, compareFinalNewline, compareTrailingWhitespace:
+		return nil
+	}
+	return fmt.Errorf("invalid compare policy %q (must be %q, %q or %q)", p, compareExact, compareFinalNewline, compareTrailingWhitespace)
+}
+
+// sameContents returns whether the destination contents destContents are
+// up to date with the generated contents b, so that formatters adding or
+// stripping trailing newlines or whitespace don't cause perpetual rewrites.
+func (c *config) sameContents(b, destContents []byte) bool {
+	if bytes.Equal(b, destContents) {
+		return true
+	}
+	switch c.comparePolicy() {
+	case compareFinalNewline:
+		return bytes.Equal(trimFinalNewlines(b), trimFinalNewlines(destContents))
+	case compareTrailingWhitespace:
+		return bytes.Equal(trimTrailingWhitespace(b), trimTrailingWhitespace(destContents))
+	}
+	return false
+}
+
+// comparisonHash returns the hash of b with the differences ignored by the
+// compare policy normalized away, so that files that are the same according
+// to sameContents have the same hash.
+func (c *config) comparisonHash(b []byte) string {
+	switch c.comparePolicy() {
+	case compareFinalNewline:
+		b = trimFinalNewlines(b)
+	case compareTrailingWhitespace:
+		b = trimTrailingWhitespace(b)
+	}
+	return contentHash(b)
+}
+
+func trimFinalNewlines(b []byte) []byte {
+	return bytes.TrimRight(b, "\r\n")
+}
+
+// trimTrailingWhitespace removes the whitespace at the end of each line of
+// b, and the newlines at its end.
+func trimTrailingWhitespace(b []byte) []byte {
+	lines := bytes.Split(b, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimRight(line, " \t\r")
+	}
+	return trimFinalNewlines(bytes.Join(lines, []byte("\n")))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileName = ".pbsync.yaml"
+	// localConfigFileName is the per-user overlay of the config, which
+	// shouldn't be checked in.
+	localConfigFileName = ".pbsync.local.yaml"
+
+	// Policies for empty generated files.
+	emptyFileError = "error"
+	emptyFileSkip  = "skip"
+	emptyFileCopy  = "copy"
+)
+
+var (
+	// defaultEmptyFilePolicies are the policies for languages not listed in
+	// the config's empty_files. TS rules legitimately emit empty
+	// declarations for protos without messages, while an empty Go file is
+	// never valid.
+	defaultEmptyFilePolicies = map[string]string{
+		"ts": emptyFileCopy,
+	}
+)
+
+// config is the per-workspace configuration, read from .pbsync.yaml in the
+// workspace root.
+type config struct {
+	// External lists go_proto_library rules from external repositories
+	// whose outputs are vendored into the workspace.
+	External []*externalRule `yaml:"external"`
+
+	// EmptyFiles maps languages ("go", "ts", ...) to how empty generated
+	// files are handled: "error" (the default), "skip" or "copy".
+	EmptyFiles map[string]string `yaml:"empty_files"`
+
+	// OutputGroups maps rule kinds to the output groups whose files are
+	// synced, instead of the files the rule kind is known to generate.
+	// Outputs are then resolved with `bazel cquery`.
+	OutputGroups map[string][]string `yaml:"output_groups"`
+
+	// AuxiliarySuffixes maps rule kinds to the suffixes of extra files
+	// generated next to their outputs by additional plugins (e.g.
+	// ".pb.json.go"), which are synced along with them.
+	AuxiliarySuffixes map[string][]string `yaml:"auxiliary_suffixes"`
+
+	// Newlines is how the line endings of synced files are handled:
+	// "preserve" (the default), "lf", "match" or "gitattributes".
+	Newlines string `yaml:"newlines"`
+
+	// DestRoots maps languages ("go", "ts", ...) to workspace-relative
+	// directories that their generated files are synced below, at the
+	// paths they would otherwise have in the workspace.
+	DestRoots map[string]string `yaml:"dest_roots"`
+
+	// Compare is how destinations are compared to the generated files to
+	// decide whether they are up to date: "exact" (the default),
+	// "final-newline" or "trailing-whitespace".
+	Compare string `yaml:"compare"`
+
+	// GoWork is whether the Go modules that Go files are synced to are
+	// added to the workspace's go.work.
+	GoWork bool `yaml:"go_work"`
+
+	// OutputRoot is the directory (e.g. a separate git worktree)
+	// generated files are written to instead of the workspace, at the same
+	// relative paths. Relative paths are relative to the workspace root.
+	OutputRoot string `yaml:"output_root"`
+
+	// Protected lists patterns (relative to the workspace root, with "**"
+	// matching any number of directories) of files that pbsync must never
+	// write, whatever destination the mapping computes for them.
+	Protected []string `yaml:"protected"`
+
+	// Profiles maps profile names to the flag values (keyed by flag name)
+	// used with --profile.
+	Profiles map[string]map[string]any `yaml:"profiles"`
+}
+
+// outputGroups returns the output groups configured for the given rule kind.
+func (c *config) outputGroups(kind string) []string {
+	if c == nil {
+		return nil
+	}
+	return c.OutputGroups[kind]
+}
+
+// auxiliarySuffixes returns the auxiliary output suffixes configured for
+// the given rule kind.
+func (c *config) auxiliarySuffixes(kind string) []string {
+	if c == nil {
+		return nil
+	}
+	return c.AuxiliarySuffixes[kind]
+}
+
+// emptyFilePolicy returns how an empty generated file for the given
+// language should be handled.
+func (c *config) emptyFilePolicy(lang string) string {
+	if c != nil {
+		if p, ok := c.EmptyFiles[lang]; ok {
+			return p
+		}
+	}
+	if p, ok := defaultEmptyFilePolicies[lang]; ok {
+		return p
+	}
+	return emptyFileError
+}
+
+// externalRule maps an external go_proto_library rule to the workspace
+// directory where its generated files are vendored.
+type externalRule struct {
+	// Rule is the rule's label, e.g.
+	// "@go_googleapis//google/api:annotations_go_proto".
+	Rule string `yaml:"rule"`
+	// Dest is the workspace-relative directory to copy the generated files
+	// to, e.g. "third_party/googleapis/google/api".
+	Dest string `yaml:"dest"`
+}
+
+// loadConfig reads the workspace config, overlaid with the local config,
+// if any. A missing config file is not an error; it results in the default
+// config.
+func loadConfig(workspaceRoot string) (*config, error) {
+	cfg := &config{}
+	path := filepath.Join(workspaceRoot, configFileName)
+	for _, p := range []string{path, filepath.Join(workspaceRoot, localConfigFileName)} {
+		b, err := os.ReadFile(fsPath(p))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", p, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		if err := expandConfigVars(workspaceRoot, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %s", p, err)
+		}
+		// Values in the local config replace those of the shared config,
+		// except for maps, whose keys are merged.
+		if err := doc.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", p, err)
+		}
+	}
+	if err := applyProfileConfig(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, r := range cfg.External {
+		if r.Rule == "" || r.Dest == "" {
+			return nil, fmt.Errorf("%s: external entries must set both rule and dest", path)
+		}
+	}
+	for lang, p := range cfg.EmptyFiles {
+		switch p {
+		case emptyFileError, emptyFileSkip, emptyFileCopy:
+		default:
+			return nil, fmt.Errorf("%s: invalid empty_files policy %q for %s", path, p, lang)
+		}
+	}
+	if err := validateProtectedPatterns(cfg.Protected); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := validateNewlinePolicy(cfg.Newlines); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := validateComparePolicy(cfg.Compare); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for kind, groups := range cfg.OutputGroups {
+		if !isSupportedKind(kind) {
+			return nil, fmt.Errorf("%s: output_groups: unsupported rule kind %q", path, kind)
+		}
+		if len(groups) == 0 {
+			return nil, fmt.Errorf("%s: output_groups: no groups listed for %s", path, kind)
+		}
+	}
+	if err := validateDestRoots(cfg.DestRoots); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := validateAuxiliarySuffixes(cfg.AuxiliarySuffixes); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// isSupportedKind returns whether pbsync knows how to sync the outputs of
+// rules of the given kind.
+func isSupportedKind(kind string) bool {
+	_, grpc := protoGRPCRules[kind]
+	return grpc || kind == goProtoLibrary || kind == tsProtoLibrary
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configVarRe = regexp.MustCompile(`\$\{(\w+)\}`)
+)
+
+// expandConfigVars expands the ${NAME} references in the string values of
+// the parsed config node to the values of the environment variables, or of
+// the built-in WORKSPACE (the workspace root) and BAZEL_BIN (the bazel-bin
+// directory) variables if no such environment variables are set. Comments
+// and keys are left alone, and expanded values stay strings whatever they
+// contain.
+func expandConfigVars(workspaceRoot string, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag != "!!str" {
+			return nil
+		}
+		v, err := expandConfigString(workspaceRoot, node.Value)
+		if err != nil {
+			return err
+		}
+		node.Value = v
+	case yaml.MappingNode:
+		// Keys and values alternate; only expand the values.
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := expandConfigVars(workspaceRoot, node.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, n := range node.Content {
+			if err := expandConfigVars(workspaceRoot, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func expandConfigString(workspaceRoot, s string) (string, error) {
+	var err error
+	res := configVarRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := configVarRe.FindStringSubmatch(ref)[1]
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		switch name {
+		case "WORKSPACE":
+			return workspaceRoot
+		case "BAZEL_BIN":
+			dir, e := getBazelBinDir(workspaceRoot)
+			if e != nil && err == nil {
+				err = fmt.Errorf("failed to expand ${BAZEL_BIN}: %s", e)
+			}
+			return dir
+		}
+		if err == nil {
+			err = fmt.Errorf("${%s} is not set", name)
+		}
+		return ref
+	})
+	return res, err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+var (
+	cloneFiles   = flag.Bool("clone", true, "Copy generated files using copy-on-write clones (APFS clonefile, btrfs/xfs reflinks) when the filesystem supports it.")
+	verifyWrites = flag.Bool("verify-writes", false, "Read back each written file and compare its checksum with the generated file's, failing on any mismatch (for flaky filesystems).")
+
+	errCloneUnsupported = errors.New("file cloning is not supported on this platform")
+
+	tempFileCounter int64
+)
+
+// writeDest writes the generated file src, whose contents have already been
+// read into srcContents, to dest. src is empty if srcContents differ from
+// the file's contents, in which case it can't be cloned.
+func writeDest(src, dest string, srcContents []byte) error {
+	if err := os.MkdirAll(fsPath(filepath.Dir(dest)), 0755); err != nil {
+		return err
+	}
+	if networkFS() {
+		// Renames over existing files aren't reliable on every network
+		// filesystem (SMB in particular), so write in place.
+		return writeFileSync(fsPath(dest), srcContents, 0644)
+	}
+	if *cloneFiles && src != "" {
+		if err := cloneDest(src, dest); err == nil {
+			return nil
+		}
+		// Cloning fails across filesystems and on filesystems without
+		// copy-on-write support; fall back to a regular copy.
+	}
+	return os.WriteFile(fsPath(dest), srcContents, 0644)
+}
+
+// verifyWrite checks that dest, which was just written, has the contents b.
+func verifyWrite(dest string, b []byte) error {
+	written, err := os.ReadFile(fsPath(dest))
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %s", dest, err)
+	}
+	if want, got := contentHash(b), contentHash(written); got != want {
+		return fmt.Errorf("%s is corrupt after writing it: expected sha256 %s (%d bytes), read back %s (%d bytes)", dest, want, len(b), got, len(written))
+	}
+	return nil
+}
+
+// cloneDest clones src to a temporary file next to dest and renames it into
+// place, since clones can't be made on top of an existing file.
+func cloneDest(src, dest string) error {
+	tmp := tempPath(dest)
+	if err := cloneFile(fsPath(src), fsPath(tmp)); err != nil {
+		return err
+	}
+	if err := os.Rename(fsPath(tmp), fsPath(dest)); err != nil {
+		os.Remove(fsPath(tmp))
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic writes b to path, creating its directory if needed, by
+// renaming a temporary file into place, so that readers never see a
+// partially written file.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(fsPath(filepath.Dir(path)), 0755); err != nil {
+		return err
+	}
+	tmp := tempPath(path)
+	if err := os.WriteFile(fsPath(tmp), b, perm); err != nil {
+		os.Remove(fsPath(tmp))
+		return err
+	}
+	if err := os.Rename(fsPath(tmp), fsPath(path)); err != nil {
+		os.Remove(fsPath(tmp))
+		return err
+	}
+	return nil
+}
+
+// tempPath returns a path next to path that can be used to stage a write.
+func tempPath(path string) string {
+	n := atomic.AddInt64(&tempFileCounter, 1)
+	return fmt.Sprintf("%s.pbsync-%d-%d.tmp", path, os.Getpid(), n)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	debounceKey = "debounce"
+)
+
+var (
+	debounce = flag.Duration("debounce", 0, "Wait this long before syncing, and coalesce the requests of all pbsync invocations for the workspace that arrive in the meantime into a single sync by the last of them (e.g. for editor save hooks running pbsync per file).")
+)
+
+// debounceQueue holds the requests of the pbsync invocations waiting to be
+// coalesced. It is stored in the user cache dir.
+type debounceQueue struct {
+	// Seq identifies the latest request; only the invocation that made it
+	// runs the sync.
+	Seq int64 `json:"seq"`
+	// All is whether any request was for all protos.
+	All bool `json:"all"`
+	// Protos are the absolute paths of the requested protos.
+	Protos []string `json:"protos"`
+}
+
+// debounced wraps syncFunc to coalesce requests arriving within --debounce.
+func debounced(syncFunc workspaceSyncFunc) workspaceSyncFunc {
+	return func(ctx context.Context, workspaceRoot string, protoList []string) (*result, error) {
+		protos, run, err := debounceRequest(ctx, workspaceRoot, protoList)
+		if err != nil {
+			return nil, err
+		}
+		if !run {
+			debugf("%s: request coalesced into a later pbsync invocation", workspaceRoot)
+			return &result{}, nil
+		}
+		return syncFunc(ctx, workspaceRoot, protos)
+	}
+}
+
+// debounceRequest queues the request for protoList (nil meaning all protos)
+// and waits for --debounce. If no other request arrived in the meantime, it
+// returns the protos of all queued requests to sync; otherwise run is false,
+// and the latest invocation syncs them.
+func debounceRequest(ctx context.Context, workspaceRoot string, protoList []string) (protos []string, run bool, err error) {
+	path, err := cachePath(cacheKey(debounceKey, workspaceRoot))
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(fsPath(filepath.Dir(path)), 0755); err != nil {
+		return nil, false, err
+	}
+
+	var seq int64
+	err = updateDebounceQueue(path, func(q *debounceQueue) bool {
+		q.Seq++
+		seq = q.Seq
+		if protoList == nil {
+			q.All = true
+		}
+		for _, p := range protoList {
+			if p == "" {
+				continue
+			}
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(workspaceRoot, p)
+			}
+			q.Protos = append(q.Protos, p)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// If interrupted, the queued request is left for the next invocation.
+	if err := sleepContext(ctx, *debounce); err != nil {
+		return nil, false, err
+	}
+
+	err = updateDebounceQueue(path, func(q *debounceQueue) bool {
+		if q.Seq != seq {
+			return false
+		}
+		run = true
+		if !q.All {
+			protos = dedupeStrings(q.Protos)
+			// Distinguish an empty list from "all protos".
+			if protos == nil {
+				protos = []string{}
+			}
+		}
+		*q = debounceQueue{Seq: q.Seq}
+		return true
+	})
+	return protos, run, err
+}
+
+// updateDebounceQueue calls update with the queue at path, under a lock, and
+// saves it if update returns true.
+func updateDebounceQueue(path string, update func(q *debounceQueue) bool) error {
+	unlock, err := lockFile(fsPath(path + ".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	q := &debounceQueue{}
+	if b, err := os.ReadFile(fsPath(path)); err == nil {
+		if err := json.Unmarshal(b, q); err != nil {
+			debugf("ignoring corrupt debounce queue %s: %s", path, err)
+			q = &debounceQueue{}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if !update(q) {
+		return nil
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fsPath(path), b, 0644)
+}
+
+func dedupeStrings(s []string) []string {
+	seen := map[string]bool{}
+	var res []string
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	changedOnly = flag.Bool("changed", false, "Only sync protos with uncommitted changes (according to git), along with all protos that transitively import them.")
+
+	protoImportRe = regexp.MustCompile(`(?m)^\s*import\s+(?:public\s+|weak\s+)?"([^"]+)"\s*;`)
+)
+
+const (
+	// emptyTree is the hash of git's empty tree, which repos without
+	// commits are diffed against.
+	emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+)
+
+// changedProtos returns the workspace-relative paths of the protos that have
+// uncommitted changes, including untracked protos. The workspace may be a
+// subdirectory of the git repo.
+func changedProtos(workspaceRoot string) ([]string, error) {
+	base := "HEAD"
+	if err := gitCommand(workspaceRoot, "rev-parse", "--verify", "--quiet", "HEAD").Run(); err != nil {
+		base = emptyTree
+	}
+	// Paths are relative to the workspace (--relative, and ls-files'
+	// default), and NUL-terminated so that unusual names aren't quoted.
+	changed, err := gitOutputPaths(workspaceRoot, "diff", "--name-only", "--relative", "--diff-filter=d", "-z", base, "--", "*.proto")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitOutputPaths(workspaceRoot, "ls-files", "--others", "--exclude-standard", "-z", "--", "*.proto")
+	if err != nil {
+		return nil, err
+	}
+	return append(changed, untracked...), nil
+}
+
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	return cmd
+}
+
+// gitOutputPaths runs git with the given args and returns the
+// NUL-separated paths it prints.
+func gitOutputPaths(dir string, args ...string) ([]string, error) {
+	cmd := gitCommand(dir, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %s", args[0], stderr.String())
+	}
+	var paths []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// withReverseDeps returns the given workspace-relative protos along with all
+// protos in the workspace that transitively import any of them. Imports are
+// resolved relative to the workspace root, which is where proto_library
+// rules resolve them unless an import prefix is configured.
+func withReverseDeps(workspaceRoot string, protos []string) ([]string, error) {
+	all, err := listProtos(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	importers := map[string][]string{}
+	for _, proto := range all {
+		b, err := os.ReadFile(fsPath(filepath.Join(workspaceRoot, proto)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, m := range protoImportRe.FindAllSubmatch(b, -1) {
+			imported := string(m[1])
+			importers[imported] = append(importers[imported], proto)
+		}
+	}
+
+	seen := map[string]bool{}
+	queue := []string{}
+	for _, proto := range protos {
+		proto = filepath.ToSlash(proto)
+		if !seen[proto] {
+			seen[proto] = true
+			queue = append(queue, proto)
+		}
+	}
+	for len(queue) > 0 {
+		proto := queue[0]
+		queue = queue[1:]
+		for _, importer := range importers[proto] {
+			if !seen[importer] {
+				seen[importer] = true
+				queue = append(queue, importer)
+			}
+		}
+	}
+	res := make([]string, 0, len(seen))
+	for proto := range seen {
+		res = append(res, proto)
+	}
+	sort.Strings(res)
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// applyDestRoot moves dest, a destination in the workspace, below the
+// dest_roots directory configured for its language, if any, keeping its
+// workspace-relative path: e.g. proto/foo.d.ts becomes
+// app/gen/proto/foo.d.ts with `ts: app/gen`.
+func (c *config) applyDestRoot(workspaceRoot, dest string) string {
+	if c == nil {
+		return dest
+	}
+	root, ok := c.DestRoots[fileLanguage(dest)]
+	if !ok {
+		return dest
+	}
+	rel, err := filepath.Rel(workspaceRoot, dest)
+	if err != nil || !isUnder(dest, workspaceRoot) {
+		return dest
+	}
+	return filepath.Join(workspaceRoot, filepath.FromSlash(root), rel)
+}
+
+func validateDestRoots(roots map[string]string) error {
+	for lang, root := range roots {
+		p := filepath.ToSlash(filepath.Clean(filepath.FromSlash(root)))
+		if root == "" || filepath.IsAbs(root) || strings.HasPrefix(root, "/") || p == ".." || strings.HasPrefix(p, "../") {
+			return fmt.Errorf("dest_roots: %s: %q must be a directory inside the workspace", lang, root)
+		}
+	}
+	return nil
+}
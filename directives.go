@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/build"
+)
+
+const destTagPrefix = "pbsync-dest:"
+
+// directives holds the settings given by `pbsync:` comments in a BUILD file,
+// which apply to all protos in the package, or at the top of a proto file,
+// which apply to that proto only.
+type directives struct {
+	// skip disables syncing.
+	skip bool
+	// dest is the directory, relative to the package, that generated files
+	// are copied to instead of their default destination.
+	dest string
+}
+
+// set applies a single directive, e.g. "skip" or "dest=gen/".
+func (d *directives) set(directive string) error {
+	key, value, hasValue := strings.Cut(directive, "=")
+	switch {
+	case key == "skip" && !hasValue:
+		d.skip = true
+	case key == "dest" && hasValue:
+		dest := filepath.Clean(filepath.FromSlash(value))
+		if value == "" || filepath.IsAbs(dest) || dest == ".." || strings.HasPrefix(dest, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid pbsync dest %q: must be a directory within the package", value)
+		}
+		d.dest = dest
+	default:
+		return fmt.Errorf("unknown pbsync directive %q", directive)
+	}
+	return nil
+}
+
+// parseDirectives parses the `# pbsync:` comment directives in the contents
+// of a BUILD file. Supported directives are:
+//
+//	# pbsync: skip
+//	# pbsync: dest=gen/
+func parseDirectives(buildFilePath string, contents []byte) (*directives, error) {
+	d := &directives{}
+	s := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; s.Scan(); line++ {
+		directive, ok := cutDirective(s.Text(), "#")
+		if !ok {
+			continue
+		}
+		if err := d.set(directive); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", buildFilePath, line, err)
+		}
+	}
+	return d, s.Err()
+}
+
+// protoDirectives returns the directives for protoFile: the package's
+// directives, overridden by any `// pbsync:` comments in the leading comment
+// block of the proto file. A proto that is still tracked but was deleted from
+// the worktree has no directives of its own.
+func protoDirectives(protoFile string, pkg *directives) (*directives, error) {
+	d := *pkg
+	f, err := os.Open(fsPath(protoFile))
+	if os.IsNotExist(err) {
+		return &d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "//") {
+			// Only the comments before the first statement are considered.
+			break
+		}
+		directive, ok := cutDirective(text, "//")
+		if !ok {
+			continue
+		}
+		if err := d.set(directive); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", protoFile, line, err)
+		}
+	}
+	return &d, s.Err()
+}
+
+// cutDirective returns the directive in a comment line of the form
+// "<commentPrefix> pbsync: <directive>".
+func cutDirective(line, commentPrefix string) (string, bool) {
+	text := strings.TrimSpace(line)
+	if !strings.HasPrefix(text, commentPrefix) {
+		return "", false
+	}
+	text = strings.TrimSpace(strings.TrimPrefix(text, commentPrefix))
+	if !strings.HasPrefix(text, "pbsync:") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(text, "pbsync:")), true
+}
+
+// applyDest returns the destination of a generated file of rule after
+// applying the rule's destination override or the dest directive, if any.
+func (d *directives) applyDest(workspaceRoot string, rule *languageProtoRule, dest string) string {
+	if rule.dest != "" {
+		return filepath.Join(workspaceRoot, filepath.FromSlash(rule.pkg), rule.dest, filepath.Base(dest))
+	}
+	if d.dest == "" {
+		return dest
+	}
+	// The directive is relative to the package of the BUILD file declaring
+	// the rule, which may not be the proto's directory.
+	return filepath.Join(workspaceRoot, filepath.FromSlash(rule.pkg), d.dest, filepath.Base(dest))
+}
+
+// ruleDest returns the destination override of a language proto rule,
+// given by a "pbsync-dest:<dir>" tag or a pbsync_dest attribute (e.g. set
+// by a macro).
+func ruleDest(r *build.Rule) (string, error) {
+	value := r.AttrString("pbsync_dest")
+	for _, tag := range r.AttrStrings("tags") {
+		if strings.HasPrefix(tag, destTagPrefix) {
+			value = strings.TrimPrefix(tag, destTagPrefix)
+		}
+	}
+	if value == "" {
+		return "", nil
+	}
+	d := &directives{}
+	if err := d.set("dest=" + value); err != nil {
+		return "", err
+	}
+	return d.dest, nil
+}
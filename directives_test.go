@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProtoDirectives(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name     string
+		contents string // "" means the proto doesn't exist
+		pkg      directives
+		want     directives
+	}{
+		{
+			name: "missing proto",
+			pkg:  directives{dest: "gen"},
+			want: directives{dest: "gen"},
+		},
+		{
+			name:     "no directives",
+			contents: "syntax = \"proto3\";\n",
+			pkg:      directives{dest: "gen"},
+			want:     directives{dest: "gen"},
+		},
+		{
+			name:     "leading comments",
+			contents: "// Copyright\n\n// pbsync: skip\n// pbsync: dest=out/\nsyntax = \"proto3\";\n",
+			want:     directives{skip: true, dest: "out"},
+		},
+		{
+			name:     "after first statement",
+			contents: "syntax = \"proto3\";\n// pbsync: skip\n",
+			want:     directives{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			protoFile := filepath.Join(dir, tc.name+".proto")
+			if tc.contents != "" {
+				if err := os.WriteFile(protoFile, []byte(tc.contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := protoDirectives(protoFile, &tc.pkg)
+			if err != nil {
+				t.Fatalf("protoDirectives() failed: %s", err)
+			}
+			if *got != tc.want {
+				t.Errorf("protoDirectives() = %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	estimateCommand = "estimate"
+
+	// estimateSampleFiles is the number of generated files read to measure
+	// how long reading a file takes.
+	estimateSampleFiles = 16
+)
+
+var (
+	// estimateMode is set by `pbsync estimate`, which reports how much a
+	// sync would touch and roughly how long it would take instead of
+	// syncing.
+	estimateMode bool
+)
+
+// estimate is the expected cost of syncing a workspace.
+type estimate struct {
+	buildFiles, protos, files int
+	// bytes is the total size of the generated files.
+	bytes int64
+	// unsynced is the number of destinations pbsync hasn't synced before.
+	unsynced int
+	// planning is how long finding the files to sync took.
+	planning time.Duration
+	// warm is the estimated time of a run finding everything up to date,
+	// which reads the generated files and their destinations, and cold the
+	// estimated time of a run rewriting all destinations.
+	warm, cold time.Duration
+}
+
+// estimateSync estimates the cost of syncing the actions planned in result,
+// timing the reads of a sample of the generated files.
+func estimateSync(result *result) *estimate {
+	e := &estimate{
+		buildFiles: result.buildFiles,
+		protos:     int(result.protos),
+		files:      len(result.actions),
+		planning:   result.planning,
+	}
+	var sampled int
+	var sampleTime time.Duration
+	for _, a := range result.actions {
+		if info, err := statGenerated(a.src); err == nil {
+			e.bytes += info.Size()
+		}
+		if _, err := os.Stat(fsPath(a.dest)); err != nil || result.manifestEntry(a.dest) == nil {
+			e.unsynced++
+		}
+		if sampled < estimateSampleFiles {
+			start := time.Now()
+			if _, err := readGenerated(a.src); err == nil {
+				sampleTime += time.Since(start)
+				sampled++
+			}
+		}
+	}
+	if sampled == 0 {
+		e.warm, e.cold = e.planning, e.planning
+		return e
+	}
+	perFile := sampleTime / time.Duration(sampled)
+	// Up-to-date files are read twice (the generated file and its
+	// destination), and rewritten files are also written once.
+	e.warm = e.planning + 2*perFile*time.Duration(e.files)
+	e.cold = e.planning + 3*perFile*time.Duration(e.files)
+	return e
+}
+
+func printEstimate(workspaceRoot string, e *estimate) {
+	printf("pbsync: %s: %d BUILD files, %d protos, %d generated files (%d bytes, %d not synced before)\n", workspaceRoot, e.buildFiles, e.protos, e.files, e.bytes, e.unsynced)
+	printf("pbsync: %s: estimated time: ~%s if everything is up to date, ~%s if all files are rewritten (finding them took %s)\n", workspaceRoot, roundDuration(e.warm), roundDuration(e.cold), roundDuration(e.planning))
+}
+
+// roundDuration rounds d for display.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// planExternalRules returns the sync actions copying the generated files of
+// the configured external go_proto_library rules to their vendored
+// destinations.
+func planExternalRules(workspaceRoot string, cfg *config) ([]*syncAction, error) {
+	if len(cfg.External) == 0 {
+		return nil, nil
+	}
+	bazelBin, err := getBazelBinDir(workspaceRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine bazel bin dir: %s", err)
+	}
+	repos := newExternalRepos(workspaceRoot, bazelBin)
+	var actions []*syncAction
+	for _, r := range cfg.External {
+		if !ruleSelected(r.Rule) {
+			continue
+		}
+		l, err := parseLabel(r.Rule, "")
+		if err != nil {
+			return nil, err
+		}
+		if l.repo == "" {
+			return nil, fmt.Errorf("external rule %q is not in an external repository", r.Rule)
+		}
+		// go_proto_library writes its outputs to <name>_/<importpath>/.
+		outDir := filepath.Join(repos.dir(r.Rule, l), l.pkg, l.name+"_")
+		srcs, err := findFiles(outDir, ".pb.go")
+		if err != nil {
+			return nil, fmt.Errorf("could not find generated go files for %s: %s", r.Rule, err)
+		}
+		for _, src := range srcs {
+			actions = append(actions, &syncAction{
+				protoFile: r.Rule,
+				rule:      r.Rule,
+				kind:      goProtoLibrary,
+				src:       src,
+				dest:      filepath.Join(workspaceRoot, r.Dest, filepath.Base(src)),
+			})
+		}
+	}
+	return actions, nil
+}
+
+// findFiles returns the paths of all regular files under dir (recursively)
+// whose names end with suffix.
+func findFiles(dir, suffix string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(fsPath(dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), suffix) {
+			rel, err := filepath.Rel(fsPath(dir), path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, filepath.Join(dir, rel))
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return paths, err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// networkMtimeSlack is how much older than its proto a generated file
+	// must be to count as stale on network filesystems, whose mtimes can be
+	// coarse or skewed between hosts.
+	networkMtimeSlack = 2 * time.Second
+)
+
+var (
+	strictFreshness = flag.Bool("strict-freshness", false, "Fail instead of warning when generated files are older than their .proto, without syncing those files.")
+)
+
+// isStale returns whether the generated file src is older than protoFile,
+// which means the proto was edited after src was built.
+func isStale(protoFile, src string) bool {
+	srcInfo, err := statGenerated(src)
+	if err != nil {
+		return false
+	}
+	protoInfo, err := os.Stat(fsPath(protoFile))
+	if err != nil {
+		return false
+	}
+	slack := time.Duration(0)
+	if networkFS() {
+		slack = networkMtimeSlack
+	}
+	return srcInfo.ModTime().Add(slack).Before(protoInfo.ModTime())
+}
+
+func (r *result) addStale(target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stale == nil {
+		r.stale = map[string]bool{}
+	}
+	r.stale[target] = true
+}
+
+// checkFreshness warns about (or with --strict-freshness, fails on) targets
+// whose outputs are older than their protos.
+func checkFreshness(result *result) error {
+	if len(result.stale) == 0 {
+		return nil
+	}
+	targets := make([]string, 0, len(result.stale))
+	for t := range result.stale {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	if *strictFreshness {
+		return fmt.Errorf("generated files are older than their protos; rebuild them with:\n  bazel build %s", strings.Join(targets, " "))
+	}
+	warnf("generated files are older than their protos, so stale code may have been synced; rebuild them with:\n  bazel build %s", strings.Join(targets, " "))
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	localFSMode   = "local"
+	networkFSMode = "network"
+
+	lockKey = "lock"
+)
+
+var (
+	fsMode = flag.String("fs-mode", localFSMode, `Filesystem profile for the workspace: "local", or "network" for NFS/SMB mounts, which avoids clones and renames, uses POSIX record locks, and fsyncs written files.`)
+)
+
+func validateFSMode() error {
+	switch *fsMode {
+	case localFSMode, networkFSMode:
+		return nil
+	}
+	return fmt.Errorf("invalid --fs-mode %q (must be %q or %q)", *fsMode, localFSMode, networkFSMode)
+}
+
+// networkFS returns whether the workspace should be treated as living on a
+// network filesystem, where rename semantics, lock implementations and mtime
+// granularity can't be relied on.
+func networkFS() bool {
+	return *fsMode == networkFSMode
+}
+
+// lockWorkspace takes an exclusive lock on the workspace so that concurrent
+// pbsync invocations don't race to write the same destination files. The
+// returned func releases the lock.
+func lockWorkspace(workspaceRoot string) (unlock func(), err error) {
+	path, err := cachePath(cacheKey(lockKey, workspaceRoot))
+	if err != nil {
+		return nil, err
+	}
+	path += ".lock"
+	if err := os.MkdirAll(fsPath(filepath.Dir(path)), 0755); err != nil {
+		return nil, err
+	}
+	return lockFile(fsPath(path))
+}
+
+// writeFileSync writes a file in place and fsyncs it before returning.
+func writeFileSync(path string, b []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	warnGazelle = "warn"
+	runGazelle  = "run"
+)
+
+var (
+	gazelle       = flag.String("gazelle", "", `What to do about packages that received newly created files, whose BUILD files may need updating: "warn" to list them, or "run" to run gazelle on them.`)
+	gazelleTarget = flag.String("gazelle-target", "//:gazelle", "The gazelle target that --gazelle=run runs.")
+)
+
+func validateGazelle() error {
+	switch *gazelle {
+	case "", warnGazelle, runGazelle:
+		return nil
+	}
+	return fmt.Errorf("invalid --gazelle %q (must be %q or %q)", *gazelle, warnGazelle, runGazelle)
+}
+
+func (r *result) addNewFile(dest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.newFiles = append(r.newFiles, dest)
+}
+
+// newFilePackages returns the workspace-relative directories (with forward
+// slashes) that received newly created files.
+func newFilePackages(workspaceRoot string, newFiles []string) []string {
+	dirs := map[string]bool{}
+	for _, f := range newFiles {
+		rel, err := filepath.Rel(workspaceRoot, filepath.Dir(f))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		dirs[filepath.ToSlash(rel)] = true
+	}
+	var pkgs []string
+	for d := range dirs {
+		pkgs = append(pkgs, d)
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// updateBuildFiles handles the packages that received newly created files
+// according to --gazelle.
+func updateBuildFiles(workspaceRoot string, result *result) error {
+	if *gazelle == "" {
+		return nil
+	}
+	pkgs := newFilePackages(workspaceRoot, result.newFiles)
+	if len(pkgs) == 0 {
+		return nil
+	}
+	out := &outputBlock{}
+	defer out.flush()
+	if *gazelle == warnGazelle {
+		out.warnf("new generated files were added to these packages, whose BUILD files may need updating (e.g. with gazelle):")
+		for _, pkg := range pkgs {
+			out.printf("  %s\n", pkg)
+		}
+		return nil
+	}
+	out.printf("pbsync: running gazelle on %d packages with new files\n", len(pkgs))
+	args := append([]string{"run"}, bazelConfigArgs()...)
+	args = append(append(args, *gazelleTarget, "--"), pkgs...)
+	cmd := exec.Command("bazel", args...)
+	cmd.Dir = workspaceRoot
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	out.write(stdout)
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %s: %s", *gazelleTarget, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
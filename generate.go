@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	bufGenerator    = "buf"
+	protocGenerator = "protoc"
+
+	bufGenTemplate = "buf.gen.yaml"
+)
+
+var (
+	generator     = flag.String("generator", "", `Code generator to fall back to when the workspace has no bazel-bin directory. "buf" runs `+"`buf generate`"+` using the workspace's buf.gen.yaml. "protoc" runs protoc with --protoc-plugins, and additionally covers protos that have no rule or whose bazel outputs haven't been built.`)
+	protocPlugins = flag.String("protoc-plugins", "go", "Comma-separated protoc plugins to run with --generator=protoc, e.g. \"go,go-grpc\" to run protoc-gen-go and protoc-gen-go-grpc.")
+)
+
+func validateGenerator() error {
+	switch *generator {
+	case "", bufGenerator, protocGenerator:
+		return nil
+	}
+	return fmt.Errorf("invalid --generator %q", *generator)
+}
+
+// hasBazelBin returns whether the workspace has a bazel-bin directory to sync
+// generated sources from.
+func hasBazelBin(workspaceRoot string) bool {
+	bazelBin, err := getBazelBinDir(workspaceRoot)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(fsPath(bazelBin))
+	return err == nil
+}
+
+// generateProtos generates sources for the given protos using the fallback
+// generator, then syncs the outputs into the workspace.
+func generateProtos(workspaceRoot string, protos []string, result *result) error {
+	if len(protos) == 0 {
+		return nil
+	}
+
+	outDir, err := os.MkdirTemp("", "pbsync-gen-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	switch *generator {
+	case bufGenerator:
+		err = bufGenerate(workspaceRoot, outDir, protos)
+	case protocGenerator:
+		err = protocGenerate(workspaceRoot, outDir, protos)
+	default:
+		err = fmt.Errorf("unknown generator %q", *generator)
+	}
+	if err != nil {
+		return err
+	}
+	return syncGeneratedDir(workspaceRoot, outDir, result)
+}
+
+// generatedRelpath maps the path of a generated file, relative to the
+// generator's output dir, to a workspace-relative path. Go plugins lay out
+// files by importpath (unless paths=source_relative is set); other outputs
+// are already laid out relative to the workspace. It returns false for Go
+// files that don't belong to the workspace, e.g. those of third-party protos:
+// their importpath must be provided by one of the workspace's Go modules, or,
+// in workspaces without any, map to a directory of the workspace's protos.
+func generatedRelpath(workspaceRoot, rel string) (string, bool) {
+	rel = filepath.ToSlash(rel)
+	if !strings.HasSuffix(rel, ".go") {
+		return rel, true
+	}
+	dir := path.Dir(rel)
+	if modDir, ok := goModuleDir(workspaceRoot, dir); ok {
+		return path.Join(modDir, path.Base(rel)), true
+	}
+	candidates := []string{dir}
+	if len(workspaceGoModules(workspaceRoot)) == 0 {
+		candidates = append(candidates, githubRepoRe.ReplaceAllLiteralString(dir, ""))
+	}
+	for _, c := range candidates {
+		// Source-relative outputs, or those of workspaces that don't use
+		// Go modules, are next to their protos.
+		if protos, _ := listFiles(filepath.Join(workspaceRoot, filepath.FromSlash(c)), ".proto"); len(protos) > 0 {
+			return path.Join(c, path.Base(rel)), true
+		}
+	}
+	return "", false
+}
+
+// syncGeneratedDir syncs all files under outDir into the workspace.
+func syncGeneratedDir(workspaceRoot, outDir string, result *result) error {
+	skipped := map[string]bool{}
+	err := filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(outDir, path)
+		if err != nil {
+			return err
+		}
+		wsRel, ok := generatedRelpath(workspaceRoot, rel)
+		if !ok {
+			skipped[filepath.ToSlash(filepath.Dir(rel))] = true
+			return nil
+		}
+		return syncFile(path, path, filepath.Join(result.destRoot(), filepath.FromSlash(wsRel)), result)
+	})
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		dirs := make([]string, 0, len(skipped))
+		for dir := range skipped {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		out := &outputBlock{}
+		defer out.flush()
+		out.warnf("%s: not syncing generated Go packages that aren't in any of the workspace's Go modules (check their go_package):", workspaceRoot)
+		for _, dir := range dirs {
+			out.printf("  %s\n", dir)
+		}
+	}
+	return nil
+}
+
+// bufGenerate runs `buf generate` for the given protos, writing outputs under
+// outDir.
+func bufGenerate(workspaceRoot, outDir string, protos []string) error {
+	if _, err := os.Stat(fsPath(filepath.Join(workspaceRoot, bufGenTemplate))); err != nil {
+		return fmt.Errorf("cannot use buf generator: %s", err)
+	}
+	args := []string{"generate", "--template", bufGenTemplate, "--output", outDir}
+	for _, proto := range protos {
+		rel, err := filepath.Rel(workspaceRoot, proto)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--path", rel)
+	}
+	cmd := exec.Command("buf", args...)
+	cmd.Dir = workspaceRoot
+	debugf("%s: running buf %q", workspaceRoot, args)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("buf generate failed: %s\n%s", err, b)
+	}
+	return nil
+}
+
+// protocGenerate runs protoc with the configured plugins for the given
+// protos, writing outputs under outDir.
+func protocGenerate(workspaceRoot, outDir string, protos []string) error {
+	args := []string{"-I", workspaceRoot}
+	for _, plugin := range strings.Split(*protocPlugins, ",") {
+		plugin = strings.TrimSpace(plugin)
+		if plugin == "" {
+			continue
+		}
+		args = append(args, fmt.Sprintf("--%s_out=%s", plugin, outDir))
+	}
+	for _, proto := range protos {
+		rel, err := filepath.Rel(workspaceRoot, proto)
+		if err != nil {
+			return err
+		}
+		args = append(args, rel)
+	}
+	cmd := exec.Command("protoc", args...)
+	cmd.Dir = workspaceRoot
+	debugf("%s: running protoc %q", workspaceRoot, args)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("protoc failed: %s\n%s", err, b)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"sort"
+)
+
+const (
+	mapCommand = "map"
+)
+
+var (
+	mapOutput = flag.String("o", "", "With pbsync map, the file to write the generated-files map to. Defaults to stdout.")
+
+	// mapMode is set by `pbsync map`, which reports where generated files
+	// are synced to instead of syncing them.
+	mapMode bool
+)
+
+// generatedMap maps protos to their generated files, for editor tooling
+// ("go to generated code") and linters (forbidding manual edits of
+// generated files).
+type generatedMap struct {
+	Workspaces []*generatedMapWorkspace `json:"workspaces"`
+}
+
+type generatedMapWorkspace struct {
+	// Root is the absolute path of the workspace.
+	Root string `json:"root"`
+	// Protos maps workspace-relative proto paths (or, for external rules,
+	// rule labels) to their generated files.
+	Protos map[string][]*generatedFile `json:"protos"`
+}
+
+type generatedFile struct {
+	// Path is the workspace-relative destination of the generated file.
+	Path string `json:"path"`
+	// Target is the label of the bazel target producing it.
+	Target string `json:"target"`
+}
+
+// writeGeneratedMap writes the map of the planned syncs of the workspaces to
+// -o, or stdout.
+func writeGeneratedMap(workspaces []*workspaceResult) error {
+	m := &generatedMap{Workspaces: []*generatedMapWorkspace{}}
+	for _, ws := range workspaces {
+		if ws.result == nil {
+			continue
+		}
+		mws := &generatedMapWorkspace{Root: ws.dir, Protos: map[string][]*generatedFile{}}
+		for _, a := range ws.result.actions {
+			protos := []string{a.protoFile}
+			if len(a.ruleProtos) > 0 {
+				// Outputs of the rule as a whole are listed under each
+				// of its protos.
+				protos = a.ruleProtos
+			}
+			for _, p := range protos {
+				proto := reportPath(ws.dir, p)
+				mws.Protos[proto] = append(mws.Protos[proto], &generatedFile{
+					Path:   reportPath(ws.dir, a.dest),
+					Target: a.rule,
+				})
+			}
+		}
+		for _, files := range mws.Protos {
+			sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+		}
+		m.Workspaces = append(m.Workspaces, mws)
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if *mapOutput == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(fsPath(*mapOutput), b, 0644)
+}
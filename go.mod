@@ -5,4 +5,6 @@ go 1.19
 require (
 	github.com/bazelbuild/buildtools v0.0.0-20210227132407-f2aed9ee205d
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	golang.org/x/sys v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
 )
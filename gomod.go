@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	goModuleRe = regexp.MustCompile(`(?m)^\s*module\s+"?([^\s"]+)"?`)
+
+	// goModules caches the Go modules of each workspace.
+	goModules sync.Map // workspace root -> *goModuleIndex
+)
+
+// goModule is a Go module in the workspace.
+type goModule struct {
+	// path is the module path, e.g. "github.com/acme/repo/services/api".
+	path string
+	// dir is the workspace-relative directory (with forward slashes) of
+	// its go.mod.
+	dir string
+}
+
+type goModuleIndex struct {
+	once sync.Once
+	// modules are sorted by decreasing path length, so that the first
+	// match for an importpath is the most specific module.
+	modules []goModule
+}
+
+// workspaceGoModules returns the Go modules in the workspace (found with
+// `git ls-files`), most specific first.
+func workspaceGoModules(workspaceRoot string) []goModule {
+	v, _ := goModules.LoadOrStore(workspaceRoot, &goModuleIndex{})
+	idx := v.(*goModuleIndex)
+	idx.once.Do(func() {
+		paths, err := gitListFiles(workspaceRoot, "*go.mod")
+		if err != nil {
+			debugf("%s: failed to list go.mod files: %s", workspaceRoot, err)
+			return
+		}
+		for _, p := range paths {
+			p = filepath.ToSlash(p)
+			if path.Base(p) != "go.mod" || strings.HasPrefix(p, "vendor/") || strings.Contains(p, "/vendor/") || strings.Contains(p, "testdata/") {
+				continue
+			}
+			b, err := os.ReadFile(fsPath(filepath.Join(workspaceRoot, p)))
+			if err != nil {
+				continue
+			}
+			m := goModuleRe.FindSubmatch(b)
+			if m == nil {
+				continue
+			}
+			idx.modules = append(idx.modules, goModule{path: string(m[1]), dir: path.Dir(p)})
+		}
+		sort.Slice(idx.modules, func(i, j int) bool {
+			return len(idx.modules[i].path) > len(idx.modules[j].path)
+		})
+	})
+	return idx.modules
+}
+
+// goModuleDir returns the workspace-relative directory (with forward
+// slashes) of the package with the given importpath, according to the
+// workspace's Go module providing it, if any.
+func goModuleDir(workspaceRoot, importPath string) (string, bool) {
+	for _, m := range workspaceGoModules(workspaceRoot) {
+		if importPath == m.path {
+			return m.dir, true
+		}
+		if strings.HasPrefix(importPath, m.path+"/") {
+			return path.Join(m.dir, strings.TrimPrefix(importPath, m.path+"/")), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	goPackageRe = regexp.MustCompile(`(?m)^\s*option\s+go_package\s*=\s*"([^"]*)"\s*;`)
+)
+
+// checkGoPackage warns if the go_package option in protoFile disagrees with
+// the importpath of the go_proto_library rule generating it, or with the
+// directory its generated files are synced to. Such files compile under
+// bazel (which only uses the importpath) but break `go build`.
+func checkGoPackage(workspaceRoot, protoFile string, rule *languageProtoRule, paths []srcAndDest) {
+	b, err := os.ReadFile(fsPath(protoFile))
+	if err != nil {
+		return
+	}
+	m := goPackageRe.FindSubmatch(b)
+	if m == nil {
+		return
+	}
+	// go_package may be "importpath;pkgname".
+	goPackage := strings.SplitN(string(m[1]), ";", 2)[0]
+	if goPackage != rule.importPath {
+		warnf("%s: go_package %q does not match importpath %q of %s", protoFile, goPackage, rule.importPath, rule.name)
+		return
+	}
+	if *goDest == vendorGoDest {
+		// Vendored files are placed by importpath, which was checked
+		// above.
+		return
+	}
+	wsRelpath, err := goDestDir(workspaceRoot, goPackage)
+	if err != nil {
+		// Not a workspace package; nothing to compare the destination to.
+		return
+	}
+	for _, p := range paths {
+		rel, err := filepath.Rel(workspaceRoot, filepath.Dir(p.dest))
+		if err != nil {
+			continue
+		}
+		if filepath.ToSlash(rel) != wsRelpath {
+			warnf("%s: go_package %q does not match destination directory %q", protoFile, goPackage, rel)
+			return
+		}
+	}
+}
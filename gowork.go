@@ -0,0 +1,211 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	goWorkBegin = "// Begin generated modules maintained by pbsync; do not edit."
+	goWorkEnd   = "// End generated modules maintained by pbsync."
+)
+
+var (
+	goVersionRe = regexp.MustCompile(`(?m)^\s*go\s+(\S+)`)
+)
+
+// updateGoWork adds the Go modules that Go files were synced to (e.g. a
+// generated-only module below a dest_roots directory or in the output_root)
+// to the `use` directives of the workspace's go.work, so that gopls and the
+// go command resolve them. The directives are kept in a block delimited by
+// comments, which pbsync rewrites as modules appear and disappear. go.work
+// is created if it doesn't exist yet.
+func updateGoWork(workspaceRoot, outputRoot string, actions []*syncAction) error {
+	path := filepath.Join(workspaceRoot, "go.work")
+	b, err := os.ReadFile(fsPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+	before, block, after := splitGoWork(string(b))
+
+	used := map[string]bool{}
+	for _, dir := range goWorkDirs(before + after) {
+		used[dir] = true
+	}
+	modules := map[string]bool{}
+	// Keep the modules of past syncs, which may not have been synced now.
+	for _, dir := range goWorkDirs(block) {
+		if _, err := os.Stat(fsPath(filepath.Join(workspaceRoot, filepath.FromSlash(dir), "go.mod"))); err == nil {
+			modules[dir] = true
+		}
+	}
+	for _, a := range actions {
+		if !strings.HasSuffix(a.dest, ".go") {
+			continue
+		}
+		dir := goModuleRoot(filepath.Dir(a.dest), outputRoot)
+		if dir == "" || dir == workspaceRoot {
+			continue
+		}
+		rel, err := filepath.Rel(workspaceRoot, dir)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !strings.HasPrefix(rel, "../") {
+			rel = "./" + rel
+		}
+		modules[rel] = true
+	}
+	var dirs []string
+	for dir := range modules {
+		if !used[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	if !exists {
+		if len(dirs) == 0 {
+			return nil
+		}
+		before = newGoWork(workspaceRoot)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(before)
+	if len(dirs) > 0 {
+		if before != "" && !strings.HasSuffix(before, "\n\n") {
+			if !strings.HasSuffix(before, "\n") {
+				sb.WriteString("\n")
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString(goWorkBegin + "\nuse (\n")
+		for _, dir := range dirs {
+			if strings.ContainsAny(dir, " \t\"`()") || strings.Contains(dir, "//") {
+				dir = strconv.Quote(dir)
+			}
+			sb.WriteString("\t" + dir + "\n")
+		}
+		sb.WriteString(")\n" + goWorkEnd + "\n")
+	}
+	sb.WriteString(after)
+	if sb.String() == string(b) {
+		return nil
+	}
+	debugf("%s: updating go.work with generated modules %s", workspaceRoot, dirs)
+	return os.WriteFile(fsPath(path), []byte(sb.String()), 0644)
+}
+
+// splitGoWork splits the contents of a go.work into the parts before,
+// inside and after the block maintained by pbsync.
+func splitGoWork(s string) (before, block, after string) {
+	i := strings.Index(s, goWorkBegin)
+	if i < 0 {
+		return s, "", ""
+	}
+	j := strings.Index(s[i:], goWorkEnd)
+	if j < 0 {
+		return s, "", ""
+	}
+	j += i
+	k := j + len(goWorkEnd)
+	if k < len(s) && s[k] == '\n' {
+		k++
+	}
+	return s[:i], s[i+len(goWorkBegin) : j], s[k:]
+}
+
+// goWorkDirs returns the directories listed in the use directives of the
+// go.work contents s.
+func goWorkDirs(s string) []string {
+	var dirs []string
+	inUse := false
+	for _, line := range strings.Split(s, "\n") {
+		fields := goWorkFields(line)
+		switch {
+		case len(fields) == 0:
+		case inUse && fields[0] == ")":
+			inUse = false
+		case inUse:
+			dirs = append(dirs, fields[0])
+		case fields[0] == "use" && len(fields) > 1 && fields[1] == "(":
+			inUse = true
+		case fields[0] == "use" && len(fields) > 1:
+			dirs = append(dirs, fields[1])
+		}
+	}
+	for i, dir := range dirs {
+		if dir != "." && !strings.HasPrefix(dir, "./") && !strings.HasPrefix(dir, "../") {
+			dirs[i] = "./" + dir
+		}
+	}
+	return dirs
+}
+
+// goWorkFields splits a go.work line into its tokens, unquoting quoted
+// strings and dropping any trailing comment.
+func goWorkFields(line string) []string {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		switch {
+		case line == "" || strings.HasPrefix(line, "//"):
+			return fields
+		case line[0] == '(' || line[0] == ')':
+			fields = append(fields, line[:1])
+			line = line[1:]
+		case line[0] == '"' || line[0] == '`':
+			q, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return append(fields, line)
+			}
+			unquoted, _ := strconv.Unquote(q)
+			fields = append(fields, unquoted)
+			line = line[len(q):]
+		default:
+			i := strings.IndexAny(line, " \t\r()")
+			if j := strings.Index(line, "//"); j >= 0 && (i < 0 || j < i) {
+				i = j
+			}
+			if i < 0 {
+				i = len(line)
+			}
+			fields = append(fields, line[:i])
+			line = line[i:]
+		}
+	}
+}
+
+// goModuleRoot returns the directory of the go.mod of the module containing
+// dir, looking no further up than root, or "" if there is none.
+func goModuleRoot(dir, root string) string {
+	for d := dir; isUnder(d, root); d = filepath.Dir(d) {
+		if _, err := os.Stat(fsPath(filepath.Join(d, "go.mod"))); err == nil {
+			return d
+		}
+		if d == root {
+			break
+		}
+	}
+	return ""
+}
+
+// newGoWork returns the start of a new go.work for the workspace, using the
+// Go version and module of its root go.mod, if any.
+func newGoWork(workspaceRoot string) string {
+	b, err := os.ReadFile(fsPath(filepath.Join(workspaceRoot, "go.mod")))
+	if err != nil {
+		return ""
+	}
+	s := ""
+	if m := goVersionRe.FindSubmatch(b); m != nil {
+		s = "go " + string(m[1]) + "\n\n"
+	}
+	return s + "use .\n\n"
+}
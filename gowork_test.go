@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoWorkDirs(t *testing.T) {
+	for _, tc := range []struct {
+		name, work string
+		want       []string
+	}{
+		{"empty", "go 1.21\n", nil},
+		{"single", "go 1.21\n\nuse ./a\n", []string{"./a"}},
+		{"single unprefixed", "use a\n", []string{"./a"}},
+		{"single quoted", "use \"./a b\"\n", []string{"./a b"}},
+		{"quoted with slashes", "use \"./a//b\" // comment\n", []string{"./a//b"}},
+		{"block quoted", "use (\n\t`./a b`\n)\n", []string{"./a b"}},
+		{"block unspaced", "use(\n\t./a\n)\n", []string{"./a"}},
+		{"dot and parent", "use .\nuse ../other\n", []string{".", "../other"}},
+		{"block", "use (\n\t.\n\t./a\n\tb\n)\n", []string{".", "./a", "./b"}},
+		{"block and single", "use (\n\t./a\n)\n\nuse ./b\n", []string{"./a", "./b"}},
+		{"trailing comments", "use ./a // the a module\nuse ( // modules\n\t./b // b\n)\n", []string{"./a", "./b"}},
+		{"commented out", "// use ./a\nuse (\n\t// ./b\n\t./c\n)\n", []string{"./c"}},
+		{"other directives", "go 1.21\n\ntoolchain go1.21.0\n\nreplace x => ./x\n", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := goWorkDirs(tc.work); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("goWorkDirs(%q) = %q, want %q", tc.work, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitGoWork(t *testing.T) {
+	block := goWorkBegin + "\nuse (\n\t./gen\n)\n" + goWorkEnd + "\n"
+	for _, tc := range []struct {
+		name, work            string
+		before, inside, after string
+	}{
+		{"no block", "go 1.21\n\nuse .\n", "go 1.21\n\nuse .\n", "", ""},
+		{"block at end", "use .\n\n" + block, "use .\n\n", "\nuse (\n\t./gen\n)\n", ""},
+		{"block in middle", "use .\n\n" + block + "\nuse ./b\n", "use .\n\n", "\nuse (\n\t./gen\n)\n", "\nuse ./b\n"},
+		{"unterminated block", "use .\n" + goWorkBegin + "\nuse ./gen\n", "use .\n" + goWorkBegin + "\nuse ./gen\n", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before, inside, after := splitGoWork(tc.work)
+			if before != tc.before || inside != tc.inside || after != tc.after {
+				t.Errorf("splitGoWork(%q) = %q, %q, %q, want %q, %q, %q", tc.work, before, inside, after, tc.before, tc.inside, tc.after)
+			}
+		})
+	}
+}
+
+func TestUpdateGoWork(t *testing.T) {
+	block := func(dirs ...string) string {
+		s := goWorkBegin + "\nuse (\n"
+		for _, dir := range dirs {
+			s += "\t" + dir + "\n"
+		}
+		return s + ")\n" + goWorkEnd + "\n"
+	}
+	for _, tc := range []struct {
+		name string
+		// work is the go.work before the update, or "" if there is none.
+		work  string
+		dests []string
+		want  string
+	}{
+		{
+			name:  "no go.work and no generated modules",
+			dests: []string{"api/api.pb.go"},
+			want:  "",
+		},
+		{
+			name:  "new go.work",
+			dests: []string{"gen/api/api.pb.go", "gen/api/api.d.ts"},
+			want:  "go 1.21\n\nuse .\n\n" + block("./gen"),
+		},
+		{
+			name:  "existing single-line use",
+			work:  "go 1.21\n\nuse .\n",
+			dests: []string{"gen/api/api.pb.go"},
+			want:  "go 1.21\n\nuse .\n\n" + block("./gen"),
+		},
+		{
+			name:  "quoted module dir",
+			dests: []string{"gen v2/api/api.pb.go"},
+			want:  "go 1.21\n\nuse .\n\n" + block(`"./gen v2"`),
+		},
+		{
+			name:  "module already used in a block",
+			work:  "go 1.21\n\nuse (\n\t.\n\t./gen // generated\n)\n",
+			dests: []string{"gen/api/api.pb.go"},
+			want:  "go 1.21\n\nuse (\n\t.\n\t./gen // generated\n)\n",
+		},
+		{
+			name:  "past modules are kept",
+			work:  "go 1.21\n\nuse .\n\n" + block("./other"),
+			dests: []string{"gen/api/api.pb.go"},
+			want:  "go 1.21\n\nuse .\n\n" + block("./gen", "./other"),
+		},
+		{
+			name:  "removed modules are dropped",
+			work:  "go 1.21\n\nuse .\n\n" + block("./gone"),
+			dests: []string{"api/api.pb.go"},
+			want:  "go 1.21\n\nuse .\n\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			write := func(rel, s string) {
+				path := filepath.Join(root, filepath.FromSlash(rel))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			write("go.mod", "module example.com/repo\n\ngo 1.21\n")
+			write("gen/go.mod", "module example.com/repo/gen\n")
+			write("other/go.mod", "module example.com/repo/other\n")
+			write("gen v2/go.mod", "module example.com/repo/gen/v2\n")
+			if tc.work != "" {
+				write("go.work", tc.work)
+			}
+			var actions []*syncAction
+			for _, dest := range tc.dests {
+				actions = append(actions, &syncAction{dest: filepath.Join(root, filepath.FromSlash(dest))})
+			}
+			if err := updateGoWork(root, root, actions); err != nil {
+				t.Fatalf("updateGoWork() failed: %s", err)
+			}
+			b, err := os.ReadFile(filepath.Join(root, "go.work"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if got := string(b); got != tc.want {
+				t.Errorf("go.work = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	here = flag.Bool("here", false, "Only sync the protos under the working directory, rather than all protos in the workspace.")
+
+	// hereDir is the working directory, if --here is set.
+	hereDir string
+)
+
+func validateHere(workspaceRoots []string) error {
+	if !*here {
+		return nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to determine working dir: %s", err)
+	}
+	for _, root := range workspaceRoots {
+		if isUnder(cwd, root) {
+			hereDir = cwd
+			return nil
+		}
+	}
+	return fmt.Errorf("--here: the working directory %s is not inside the synced workspace", cwd)
+}
+
+// isUnder returns whether path is dir or inside it.
+func isUnder(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// filterHere returns the protos under the working directory if --here is
+// set, or else all protos.
+func filterHere(protos []string) []string {
+	if hereDir == "" {
+		return protos
+	}
+	var res []string
+	for _, p := range protos {
+		if isUnder(p, hereDir) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	statsHistoryCommand = "stats history"
+
+	historyKey = "history"
+	// maxHistoryRuns is the number of past runs kept in the history.
+	maxHistoryRuns = 500
+	// maxHistoryTop is the number of protos and outputs listed by
+	// `pbsync stats history`.
+	maxHistoryTop = 10
+)
+
+var (
+	historyRuns = flag.Int("history-runs", 10, "Number of recent runs listed by `pbsync stats history`.")
+)
+
+// history records the churn of past syncs of a workspace: the files and
+// bytes they rewrote. It is stored in the user cache dir.
+type history struct {
+	Runs []*historyRun `json:"runs"`
+}
+
+type historyRun struct {
+	Time time.Time `json:"time"`
+	// Files is the number of files written.
+	Files int `json:"files"`
+	// Bytes is the number of bytes written.
+	Bytes int64 `json:"bytes"`
+	// Protos maps workspace-relative proto paths to the number of files
+	// generated from them that were written.
+	Protos map[string]int `json:"protos,omitempty"`
+	// Outputs maps the kinds of generated files, named after their suffix
+	// (e.g. ".pb.go" or "_grpc.pb.go"), to the number written.
+	Outputs map[string]int `json:"outputs,omitempty"`
+}
+
+func (r *result) recordChurn(protoFile, dest string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.churn == nil {
+		r.churn = &historyRun{Protos: map[string]int{}, Outputs: map[string]int{}}
+	}
+	r.churn.Files++
+	r.churn.Bytes += int64(n)
+	if filepath.Ext(protoFile) != ".proto" {
+		return
+	}
+	r.churn.Protos[protoKey(r.workspaceRoot, protoFile)]++
+	r.churn.Outputs[outputKind(protoFile, dest)]++
+}
+
+// outputKind returns the suffix of dest following the base name of the
+// proto it was generated from, e.g. "_grpc.pb.go" for foo_grpc.pb.go.
+func outputKind(protoFile, dest string) string {
+	protoBase := strings.TrimSuffix(filepath.Base(protoFile), ".proto")
+	base := filepath.Base(dest)
+	if kind := strings.TrimPrefix(base, protoBase); kind != base {
+		return kind
+	}
+	if i := strings.Index(base, "."); i >= 0 {
+		return base[i:]
+	}
+	return base
+}
+
+func historyPath(workspaceRoot string) (string, error) {
+	path, err := cachePath(cacheKey(historyKey, workspaceRoot))
+	if err != nil {
+		return "", err
+	}
+	return path + ".json", nil
+}
+
+func loadHistory(workspaceRoot string) (*history, error) {
+	h := &history{}
+	path, err := historyPath(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(fsPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, h); err != nil {
+		warnf("ignoring corrupt history %s: %s", path, err)
+		return &history{}, nil
+	}
+	return h, nil
+}
+
+// appendHistory adds the churn of the run to the workspace's history.
+func appendHistory(workspaceRoot string, result *result) error {
+	h, err := loadHistory(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	run := result.churn
+	if run == nil {
+		run = &historyRun{}
+	}
+	run.Time = time.Now()
+	h.Runs = append(h.Runs, run)
+	if len(h.Runs) > maxHistoryRuns {
+		h.Runs = h.Runs[len(h.Runs)-maxHistoryRuns:]
+	}
+	path, err := historyPath(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b, 0644)
+}
+
+// printHistory prints the recent runs recorded for the workspace, and the
+// protos and kinds of outputs most often rewritten across all recorded runs.
+func printHistory(workspaceRoot string) error {
+	h, err := loadHistory(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	printf("%s: %d runs recorded\n", workspaceRoot, len(h.Runs))
+	if len(h.Runs) == 0 {
+		return nil
+	}
+	recent := h.Runs
+	if *historyRuns >= 0 && len(recent) > *historyRuns {
+		recent = recent[len(recent)-*historyRuns:]
+	}
+	printf("\nrecent runs:\n")
+	for _, run := range recent {
+		printf("  %s  %5d files  %10d bytes\n", run.Time.Local().Format("2006-01-02 15:04:05"), run.Files, run.Bytes)
+	}
+
+	protos := map[string]int{}
+	outputs := map[string]int{}
+	files, bytes := 0, int64(0)
+	for _, run := range h.Runs {
+		files += run.Files
+		bytes += run.Bytes
+		for p, n := range run.Protos {
+			protos[p] += n
+		}
+		for o, n := range run.Outputs {
+			outputs[o] += n
+		}
+	}
+	printf("\ntotal: %d files, %d bytes written in %d runs since %s\n", files, bytes, len(h.Runs), h.Runs[0].Time.Local().Format("2006-01-02"))
+	printTopCounts("most rewritten protos", protos)
+	printTopCounts("most rewritten outputs", outputs)
+	return nil
+}
+
+func printTopCounts(title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > maxHistoryTop {
+		keys = keys[:maxHistoryTop]
+	}
+	printf("\n%s:\n", title)
+	for _, k := range keys {
+		printf("  %5d  %s\n", counts[k], k)
+	}
+}
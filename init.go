@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/build"
+)
+
+const (
+	initCommand = "init"
+
+	// gitHookScript is the script of the git hooks installed by
+	// `pbsync init --hooks`.
+	gitHookScript = `#!/bin/sh
+# Installed by pbsync init: syncs generated proto code into the workspace.
+command -v pbsync >/dev/null 2>&1 && pbsync --quiet
+exit 0
+`
+)
+
+var (
+	initHooks = flag.Bool("hooks", false, "With `pbsync init`, also install post-checkout and post-merge git hooks running pbsync.")
+
+	// gitHooks are the hooks installed by `pbsync init --hooks`.
+	gitHooks = []string{"post-checkout", "post-merge"}
+)
+
+// workspaceSurvey is what `pbsync init` found out about a workspace.
+type workspaceSurvey struct {
+	// buildFileNames counts BUILD files by name (BUILD or BUILD.bazel).
+	buildFileNames map[string]int
+	// protoRules is the number of proto_library rules.
+	protoRules int
+	// supported and unsupported count the language rules next to
+	// proto_library rules by kind.
+	supported, unsupported map[string]int
+	// goModules are the workspace's Go modules.
+	goModules []goModule
+	// hasThirdParty is whether the workspace has a third_party directory.
+	hasThirdParty bool
+}
+
+// initWorkspace writes a starter .pbsync.yaml for the workspace, based on
+// what it contains, and optionally installs git hooks.
+func initWorkspace(workspaceRoot string) error {
+	configPath := filepath.Join(workspaceRoot, configFileName)
+	if _, err := os.Stat(fsPath(configPath)); err == nil {
+		return fmt.Errorf("%s already exists", configPath)
+	}
+	s, err := surveyWorkspace(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	printSurvey(workspaceRoot, s)
+	if err := os.WriteFile(fsPath(configPath), starterConfig(s), 0644); err != nil {
+		return err
+	}
+	printf("pbsync: wrote %s\n", configPath)
+	if err := ignoreLocalConfig(workspaceRoot); err != nil {
+		warnf("failed to add %s to .gitignore: %s", localConfigFileName, err)
+	}
+	if *initHooks {
+		if err := installGitHooks(workspaceRoot); err != nil {
+			return fmt.Errorf("failed to install git hooks: %s", err)
+		}
+	}
+	return nil
+}
+
+func surveyWorkspace(workspaceRoot string) (*workspaceSurvey, error) {
+	s := &workspaceSurvey{
+		buildFileNames: map[string]int{},
+		supported:      map[string]int{},
+		unsupported:    map[string]int{},
+		goModules:      workspaceGoModules(workspaceRoot),
+	}
+	if info, err := os.Stat(fsPath(filepath.Join(workspaceRoot, "third_party"))); err == nil && info.IsDir() {
+		s.hasThirdParty = true
+	}
+	var paths []string
+	for _, pathspec := range []string{"BUILD", "*/BUILD", "BUILD.bazel", "*/BUILD.bazel"} {
+		p, err := gitListFiles(workspaceRoot, pathspec)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p...)
+	}
+	for _, p := range paths {
+		s.buildFileNames[filepath.Base(p)]++
+		b, err := os.ReadFile(fsPath(filepath.Join(workspaceRoot, p)))
+		if err != nil {
+			return nil, err
+		}
+		f, err := build.ParseBuild(p, b)
+		if err != nil {
+			debugf("%s: skipping unparseable BUILD file: %s", p, err)
+			continue
+		}
+		protoRuleNames := map[string]bool{}
+		for _, r := range f.Rules("proto_library") {
+			protoRuleNames[r.Name()] = true
+			s.protoRules++
+		}
+		if len(protoRuleNames) == 0 {
+			continue
+		}
+		for _, r := range f.Rules("") {
+			kind := r.Kind()
+			switch {
+			case isSupportedKind(kind):
+				s.supported[kind]++
+			case isProtoAdjacent(r, protoRuleRefs(r, protoRuleNames)):
+				s.unsupported[kind]++
+			}
+		}
+	}
+	return s, nil
+}
+
+func printSurvey(workspaceRoot string, s *workspaceSurvey) {
+	printf("pbsync: %s: %d proto_library rules in %s\n", workspaceRoot, s.protoRules, formatCounts(s.buildFileNames, "files"))
+	if len(s.supported) > 0 {
+		printf("  supported rules: %s\n", formatCounts(s.supported, ""))
+	}
+	if len(s.unsupported) > 0 {
+		printf("  unsupported rules: %s\n", formatCounts(s.unsupported, ""))
+	}
+	for _, m := range s.goModules {
+		printf("  go module: %s (%s)\n", m.path, m.dir)
+	}
+	if s.buildFileNames["BUILD.bazel"] > 0 {
+		warnf("pbsync only reads BUILD files so far; protos in packages with BUILD.bazel files won't be synced")
+	}
+}
+
+// formatCounts formats counts as "a: 1, b: 2", or as "1 a files, 2 b files"
+// if noun is set.
+func formatCounts(counts map[string]int, noun string) string {
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		if noun != "" {
+			parts = append(parts, fmt.Sprintf("%d %s %s", counts[k], k, noun))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s: %d", k, counts[k]))
+		}
+	}
+	if len(parts) == 0 {
+		return "no " + noun
+	}
+	return strings.Join(parts, ", ")
+}
+
+// starterConfig returns the contents of a .pbsync.yaml for the surveyed
+// workspace, with the options likely to be relevant commented out.
+func starterConfig(s *workspaceSurvey) []byte {
+	var b bytes.Buffer
+	b.WriteString("# pbsync configuration; see https://github.com/buildbuddy-io/pbsync#configuration\n")
+	if len(s.unsupported) > 0 {
+		var kinds []string
+		for k := range s.unsupported {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		b.WriteString("\n# These rule kinds were found next to proto_library rules, but aren't\n")
+		b.WriteString("# supported yet (see --list-unsupported):\n")
+		for _, k := range kinds {
+			fmt.Fprintf(&b, "#   %s\n", k)
+		}
+	}
+	if s.supported[tsProtoLibrary] > 0 {
+		b.WriteString("\n# How empty generated files are handled, per language: error, skip or copy.\n")
+		b.WriteString("# empty_files:\n#   ts: copy\n")
+	}
+	b.WriteString("\n# Line endings of synced files: preserve, lf, match or gitattributes.\n")
+	b.WriteString("# newlines: preserve\n")
+	b.WriteString("\n# Files pbsync must never write.\n")
+	if s.hasThirdParty {
+		b.WriteString("protected:\n  - third_party/**\n")
+	} else {
+		b.WriteString("# protected:\n#   - third_party/**\n")
+	}
+	b.WriteString("\n# Flag values per environment, selected with --profile.\n")
+	b.WriteString("# profiles:\n#   ci:\n#     quiet: true\n")
+	return b.Bytes()
+}
+
+// ignoreLocalConfig adds the local config to the workspace's .gitignore,
+// unless git already ignores it.
+func ignoreLocalConfig(workspaceRoot string) error {
+	cmd := exec.Command("git", "check-ignore", "-q", localConfigFileName)
+	cmd.Dir = workspaceRoot
+	if err := cmd.Run(); err == nil {
+		return nil
+	}
+	path := filepath.Join(workspaceRoot, ".gitignore")
+	b, err := os.ReadFile(fsPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b, '\n')
+	}
+	b = append(b, "/"+localConfigFileName+"\n"...)
+	if err := os.WriteFile(fsPath(path), b, 0644); err != nil {
+		return err
+	}
+	printf("pbsync: added %s to %s\n", localConfigFileName, path)
+	return nil
+}
+
+// installGitHooks installs git hooks running pbsync after checkouts and
+// merges, leaving existing hooks alone.
+func installGitHooks(workspaceRoot string) error {
+	cmd := exec.Command("git", "rev-parse", "--git-path", "hooks")
+	cmd.Dir = workspaceRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git rev-parse failed: %s", err)
+	}
+	hooksDir := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(workspaceRoot, hooksDir)
+	}
+	if err := os.MkdirAll(fsPath(hooksDir), 0755); err != nil {
+		return err
+	}
+	for _, hook := range gitHooks {
+		path := filepath.Join(hooksDir, hook)
+		if _, err := os.Stat(fsPath(path)); err == nil {
+			warnf("not overwriting existing git hook %s; add `pbsync --quiet` to it to sync after %s", path, hook)
+			continue
+		}
+		if err := os.WriteFile(fsPath(path), []byte(gitHookScript), 0755); err != nil {
+			return err
+		}
+		printf("pbsync: installed git hook %s\n", path)
+	}
+	return nil
+}
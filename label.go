@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// label is a parsed Bazel label.
+type label struct {
+	// repo is the repository name, without the leading "@" (or "@@"), or ""
+	// for the main repository.
+	repo string
+	// pkg is the package path, e.g. "proto/api".
+	pkg string
+	// name is the target name.
+	name string
+}
+
+// parseLabel parses label relative to the package pkg. Relative labels
+// (":name" or "name") refer to targets in pkg.
+func parseLabel(s, pkg string) (*label, error) {
+	l := &label{pkg: pkg}
+	rest := s
+	if strings.HasPrefix(rest, "@") {
+		rest = strings.TrimLeft(rest, "@")
+		i := strings.Index(rest, "//")
+		if i < 0 {
+			// "@repo" is shorthand for "@repo//:repo".
+			l.repo, l.pkg, l.name = rest, "", rest
+			return l, nil
+		}
+		l.repo, rest = rest[:i], rest[i:]
+	}
+	if strings.HasPrefix(rest, "//") {
+		rest = rest[2:]
+		i := strings.Index(rest, ":")
+		if i < 0 {
+			// "//foo/bar" is shorthand for "//foo/bar:bar".
+			l.pkg, l.name = rest, path.Base(rest)
+		} else {
+			l.pkg, l.name = rest[:i], rest[i+1:]
+		}
+	} else {
+		l.name = strings.TrimPrefix(rest, ":")
+	}
+	if l.name == "" {
+		return nil, fmt.Errorf("invalid label %q", s)
+	}
+	return l, nil
+}
+
+func (l *label) String() string {
+	s := "//" + l.pkg + ":" + l.name
+	if l.repo != "" {
+		s = "@" + l.repo + s
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile blocks until it holds an exclusive advisory lock on path.
+//
+// flock(2) locks are not reliably propagated over NFS (notably on macOS), so
+// on network filesystems POSIX record locks are used instead, which NFS
+// supports through its lock manager.
+func lockFile(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if networkFS() {
+		lk := unix.Flock_t{Type: unix.F_WRLCK, Whence: 0}
+		err = unix.FcntlFlock(f.Fd(), unix.F_SETLKW, &lk)
+	} else {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Closing the file releases either kind of lock.
+	return func() { f.Close() }, nil
+}
+
+// isLocked returns whether another process holds a POSIX record lock on
+// path, without taking the lock.
+func isLocked(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	lk := unix.Flock_t{Type: unix.F_WRLCK, Whence: 0}
+	if err := unix.FcntlFlock(f.Fd(), unix.F_GETLK, &lk); err != nil {
+		return false, err
+	}
+	return lk.Type != unix.F_UNLCK, nil
+}
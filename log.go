@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+const (
+	// logFileBackups is the number of rotated log files to keep.
+	logFileBackups = 3
+)
+
+var (
+	logFile        = flag.String("log-file", "", "Append a debug-level log of each run to this file, regardless of --quiet. The file is rotated once it grows past --log-file-max-size.")
+	logFileMaxSize = flag.Int64("log-file-max-size", 10<<20, "Size in bytes at which the --log-file is rotated.")
+
+	// debugLog is non-nil if --log-file is set.
+	debugLog *log.Logger
+)
+
+// openLog opens the --log-file, if set. The returned func closes it.
+func openLog() (close func(), err error) {
+	if *logFile == "" {
+		return func() {}, nil
+	}
+	w := &rotatingWriter{path: *logFile, maxSize: *logFileMaxSize}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	debugLog = log.New(w, "", log.LstdFlags|log.Lmicroseconds)
+	debugf("pbsync started: %q", os.Args)
+	return func() { w.close() }, nil
+}
+
+// debugf writes a message to the --log-file, if set.
+func debugf(msg string, args ...any) {
+	if debugLog == nil {
+		return
+	}
+	debugLog.Output(2, fmt.Sprintf(msg, args...))
+}
+
+// rotatingWriter appends to a file, rotating it to path.1, path.2, ... once it
+// grows past maxSize.
+type rotatingWriter struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(fsPath(w.path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, info.Size()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		// The log file couldn't be reopened after a failed rotation; drop
+		// the output rather than failing the sync.
+		return len(p), nil
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil && w.f == nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate moves the log file to path.1 and opens a new one. If the file can't
+// be moved, the original is reopened and keeps growing. If no file can be
+// opened, w.f is left nil.
+func (w *rotatingWriter) rotate() error {
+	w.f.Close()
+	w.f = nil
+	for i := logFileBackups - 1; i >= 1; i-- {
+		os.Rename(fsPath(fmt.Sprintf("%s.%d", w.path, i)), fsPath(fmt.Sprintf("%s.%d", w.path, i+1)))
+	}
+	if err := os.Rename(fsPath(w.path), fsPath(w.path+".1")); err != nil {
+		w.open()
+		return err
+	}
+	return w.open()
+}
+
+func (w *rotatingWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f != nil {
+		w.f.Close()
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// fsPath returns the path to use for file operations on path. Only Windows
+// needs any special treatment.
+func fsPath(path string) string {
+	return path
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// fsPath returns the extended-length form of path (`\\?\C:\...`), which lifts
+// the 260 character MAX_PATH limit for file operations. go_proto_library
+// outputs nest the full importpath under bazel-bin, so they routinely exceed
+// that limit.
+func fsPath(path string) string {
+	if path == "" || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// UNC path: \\server\share\... => \\?\UNC\server\share\...
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -28,24 +33,48 @@ const (
 )
 
 var (
+	protoListFile = flag.String("protos", "", "File listing the .proto files to sync, one per line, relative to the workspace root (or \"-\" for stdin). By default, protos are discovered with `git ls-files`.")
+	bazelBinFlag  = flag.String("bazel-bin", "", "Path to the bazel-bin directory. By default, this is determined with `bazel info`.")
+
 	githubRepoRe = regexp.MustCompile(`^github.com/(.+?)/(.+?)/`)
+
+	// tsOutputAttrs are the attributes of ts_proto_library rules (or the
+	// macros wrapping them) naming their outputs explicitly.
+	tsOutputAttrs = []string{"out", "declaration"}
 )
 
 func getBazelBinDir(workspaceRoot string) (string, error) {
+	if *bazelBinFlag != "" {
+		return *bazelBinFlag, nil
+	}
+	// The symlink points at the outputs of the last build, which may have
+	// used a different --config.
+	if *bazelConfigs == "" {
+		if dir, err := symlinkBazelBin(workspaceRoot); err == nil {
+			return dir, nil
+		} else if !os.IsNotExist(err) {
+			debugf("%s: not using the bazel-bin symlink: %s", workspaceRoot, err)
+		}
+	}
 	// The `bazel info` command is unfortunately super slow (lame).
-	// So we cache it.
+	// So we cache it, along with the bazel configuration it depends on.
+	fingerprint := bazelConfigFingerprint(workspaceRoot)
 	cached, err := cacheGet(cacheKey(bazelBinKey, workspaceRoot))
 	if err != nil {
 		return "", err
 	}
-	if cached != "" {
-		return cached, nil
+	if cachedFingerprint, value, ok := strings.Cut(cached, "\n"); ok {
+		if cachedFingerprint == fingerprint {
+			return value, nil
+		}
+		debugf("%s: bazel configuration changed; ignoring cached bazel-bin", workspaceRoot)
 	}
 	value, err := computeBazelBinDir(workspaceRoot)
 	if err != nil {
 		return "", err
 	}
-	if err := cacheSet(cacheKey(bazelBinKey, workspaceRoot), value); err != nil {
+	debugf("%s: bazel-bin is %s", workspaceRoot, value)
+	if err := cacheSet(cacheKey(bazelBinKey, workspaceRoot), fingerprint+"\n"+value); err != nil {
 		return "", err
 	}
 	return value, nil
@@ -60,13 +89,22 @@ func cacheKey(keys ...string) string {
 	return fmt.Sprintf("%x", b)
 }
 
-func cachePath(key string) (string, error) {
+// cacheDir returns the directory of pbsync's cache.
+func cacheDir() (string, error) {
 	userDir, err := os.UserCacheDir()
 	if err != nil {
 		return "", err
 	}
+	return filepath.Join(userDir, "pbsync"), nil
+}
+
+func cachePath(key string) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
 	sha := fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
-	return filepath.Join(userDir, "pbsync", sha), nil
+	return filepath.Join(dir, sha), nil
 }
 
 func cacheGet(key string) (value string, err error) {
@@ -74,7 +112,7 @@ func cacheGet(key string) (value string, err error) {
 	if err != nil {
 		return "", err
 	}
-	b, err := os.ReadFile(path)
+	b, err := os.ReadFile(fsPath(path))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return "", nil
@@ -88,14 +126,14 @@ func cacheSet(key, value string) error {
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	if err := os.MkdirAll(fsPath(filepath.Dir(path)), 0755); err != nil {
 		return err
 	}
-	return os.WriteFile(path, []byte(value), 0644)
+	return os.WriteFile(fsPath(path), []byte(value), 0644)
 }
 
 func computeBazelBinDir(workspaceRoot string) (string, error) {
-	cmd := exec.Command("bazel", "info", "--show_make_env")
+	cmd := exec.Command("bazel", append([]string{"info", "--show_make_env"}, bazelConfigArgs()...)...)
 	cmd.Dir = workspaceRoot
 	b, err := cmd.CombinedOutput()
 	if err != nil {
@@ -112,56 +150,279 @@ func computeBazelBinDir(workspaceRoot string) (string, error) {
 	return "", fmt.Errorf("missing 'bazel-bin' entry in `bazel info --show_make_env`")
 }
 
+// listFiles returns the paths of the regular files directly inside dir whose
+// names end with suffix. Unlike filepath.Glob, it works with extended-length
+// Windows paths (whose `\\?\` prefix would be interpreted as a pattern).
+func listFiles(dir, suffix string) ([]string, error) {
+	entries, err := withReadTimeout(dir, func() ([]fs.DirEntry, error) {
+		return os.ReadDir(fsPath(dir))
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), suffix) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+	return paths, nil
+}
+
 type languageProtoRule struct {
 	kind, name, protoRuleName, importPath string
+	// pkg is the workspace-relative package of the rule, which may differ
+	// from the directory of its protos.
+	pkg string
+	// dest is the directory, relative to the package, that the rule opted
+	// to have its generated files copied to, if any.
+	dest string
+	// protos are the base names of the protos of a go_proto_library's
+	// proto_library.
+	protos []string
+	// pkgProtos are the base names of the protos of all go_proto_library
+	// rules in the package, whose outputs may share this rule's directories.
+	pkgProtos []string
+	// embedders are the names of the go_proto_library rules in the package
+	// that (transitively) embed this one.
+	embedders []string
+	// outs are the package-relative paths of the outputs the rule declares
+	// explicitly (see tsOutputAttrs), if any.
+	outs []string
+}
+
+// label returns the rule's label.
+func (r *languageProtoRule) label() string {
+	return "//" + r.pkg + ":" + r.name
 }
 
 type srcAndDest struct {
 	src, dest string
+	// ruleOutput is set for outputs of a multi-proto rule that can't be
+	// attributed to one of its protos.
+	ruleOutput bool
 }
 
 func (r *languageProtoRule) getSrcAndDest(workspaceRoot, bazelBin, protoPath string) ([]srcAndDest, error) {
-	protoRelpath := strings.TrimPrefix(protoPath, workspaceRoot)
+	pkgDir := filepath.FromSlash(r.pkg)
 
 	switch r.kind {
 
 	case goProtoLibrary:
-		wsRelpath := githubRepoRe.ReplaceAllLiteralString(r.importPath, "")
-		if wsRelpath == r.importPath {
-			return nil, fmt.Errorf("could not figure out workspace relative path for import %q", r.importPath)
+		wsRelpath, err := goDestDir(workspaceRoot, r.importPath)
+		if err != nil {
+			return nil, err
+		}
+		srcDir, err := goOutputDir(filepath.Join(bazelBin, pkgDir), r.name, r.importPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not find generated go files: %s", err)
 		}
-		srcDir := filepath.Join(bazelBin, filepath.Dir(protoRelpath), r.name+"_", r.importPath)
-		srcs, err := filepath.Glob(srcDir + "/*.pb.go")
+		allSrcs, err := listFiles(srcDir, ".pb.go")
 		if err != nil {
 			return nil, fmt.Errorf("could not find generated go files: %s", err)
 		}
+		protoBase := strings.TrimSuffix(filepath.Base(protoPath), ".proto")
+		var srcs, ruleSrcs []string
+		for _, src := range allSrcs {
+			// Files generated from the rule's other protos, or those of
+			// embedded rules, are synced for their own protos.
+			switch generatedFrom(filepath.Base(src), r.pkgProtos) {
+			case protoBase:
+				srcs = append(srcs, src)
+			case "":
+				if len(r.protos) > 1 {
+					ruleSrcs = append(ruleSrcs, src)
+				} else {
+					srcs = append(srcs, src)
+				}
+			}
+		}
+		// Outputs of embedded rules may end up in the directories of the
+		// rules embedding them, next to the embedder's own outputs. Only
+		// take the files generated for this proto from those, and only
+		// once.
+		for _, embedder := range r.embedders {
+			embedderDir, err := goOutputDir(filepath.Join(bazelBin, pkgDir), embedder, r.importPath)
+			if err != nil {
+				return nil, fmt.Errorf("could not find generated go files: %s", err)
+			}
+			embedderSrcs, err := listFiles(embedderDir, ".pb.go")
+			if err != nil {
+				return nil, fmt.Errorf("could not find generated go files: %s", err)
+			}
+			for _, src := range embedderSrcs {
+				if generatedFrom(filepath.Base(src), r.pkgProtos) == protoBase {
+					srcs = append(srcs, src)
+				}
+			}
+		}
 
 		res := []srcAndDest{}
+		seen := map[string]bool{}
 		for _, src := range srcs {
 			genBase := filepath.Base(src)
+			if seen[genBase] {
+				continue
+			}
+			seen[genBase] = true
 			dest := filepath.Join(workspaceRoot, wsRelpath, genBase)
 			res = append(res, srcAndDest{src: src, dest: dest})
 		}
+		for _, src := range ruleSrcs {
+			dest := filepath.Join(workspaceRoot, wsRelpath, filepath.Base(src))
+			res = append(res, srcAndDest{src: src, dest: dest, ruleOutput: true})
+		}
 
 		return res, nil
 
 	case tsProtoLibrary:
-		src := filepath.Join(bazelBin, filepath.Dir(protoRelpath), r.name+".d.ts")
-		dest := filepath.Join(workspaceRoot, filepath.Dir(protoRelpath), r.name+".d.ts")
-		return []srcAndDest{{src: src, dest: dest}}, nil
+		if len(r.outs) > 0 {
+			// The rule declares its outputs.
+			var res []srcAndDest
+			for _, out := range r.outs {
+				res = append(res, srcAndDest{
+					src:  filepath.Join(bazelBin, pkgDir, filepath.FromSlash(out)),
+					dest: filepath.Join(workspaceRoot, pkgDir, filepath.FromSlash(out)),
+				})
+			}
+			return res, nil
+		}
+		// Rules with a single proto generate <name>.d.ts, while rules with
+		// several protos may generate declarations per proto instead (or
+		// as well), named after the proto.
+		res := []srcAndDest{}
+		combined := srcAndDest{
+			src:  filepath.Join(bazelBin, pkgDir, r.name+".d.ts"),
+			dest: filepath.Join(workspaceRoot, pkgDir, r.name+".d.ts"),
+		}
+		if _, err := statGenerated(combined.src); err == nil {
+			res = append(res, combined)
+		}
+		perProto, err := tsPerProtoOutputs(workspaceRoot, bazelBin, protoPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range perProto {
+			if p.src != combined.src {
+				res = append(res, p)
+			}
+		}
+		if len(res) == 0 {
+			// Report the expected output as missing.
+			res = append(res, combined)
+		}
+		return res, nil
 
 	}
+	if kind, ok := protoGRPCRules[r.kind]; ok {
+		return r.protoGRPCSrcAndDest(workspaceRoot, bazelBin, protoPath, kind)
+	}
 	return nil, fmt.Errorf("unknown proto rule kind %q", r.kind)
 }
 
+// goOutputDir returns the directory of the files generated by the
+// go_proto_library rule name in the package directory pkgBin of bazel-bin:
+// <name>_/<importpath>. Newer rules_go versions nest it in further
+// directories (such as a configuration hash) inside <name>_/, so if it
+// doesn't exist, the directory below <name>_/ whose path ends with the
+// importpath is returned instead. Outputs left behind by builds in other
+// configurations make that ambiguous, which is reported as an error rather
+// than guessing.
+func goOutputDir(pkgBin, name, importPath string) (string, error) {
+	root := filepath.Join(pkgBin, name+"_")
+	dir := filepath.Join(root, filepath.FromSlash(importPath))
+	_, err := statGenerated(dir)
+	if err == nil {
+		return dir, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	return withReadTimeout(root, func() (string, error) {
+		var matches []string
+		err := filepath.WalkDir(fsPath(root), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(fsPath(root), path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if rel != importPath && !strings.HasSuffix(rel, "/"+importPath) {
+				return nil
+			}
+			matches = append(matches, filepath.Join(root, filepath.FromSlash(rel)))
+			return filepath.SkipDir
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		switch len(matches) {
+		case 0:
+			return dir, nil
+		case 1:
+			return matches[0], nil
+		}
+		return "", fmt.Errorf("ambiguous outputs for %s in %s, stale outputs of another configuration may need a `bazel clean`", importPath, strings.Join(matches, ", "))
+	})
+}
+
+// tsPerProtoOutputs returns the TypeScript declarations generated for
+// protoPath itself: <proto>.d.ts or <proto>_pb.d.ts next to the proto's path
+// in bazel-bin.
+func tsPerProtoOutputs(workspaceRoot, bazelBin, protoPath string) ([]srcAndDest, error) {
+	relDir, err := filepath.Rel(workspaceRoot, filepath.Dir(protoPath))
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(filepath.Base(protoPath), ".proto")
+	var res []srcAndDest
+	for _, name := range []string{base + ".d.ts", base + "_pb.d.ts"} {
+		src := filepath.Join(bazelBin, relDir, name)
+		if _, err := statGenerated(src); err != nil {
+			continue
+		}
+		res = append(res, srcAndDest{src: src, dest: filepath.Join(workspaceRoot, relDir, name)})
+	}
+	return res, nil
+}
+
 type parsedBuildFile struct {
+	// protoFileToRule maps the workspace-relative paths of the protos in
+	// the srcs of proto_library rules to the rule names.
 	protoFileToRule           map[string]string
 	protoRuleToLangProtoRules map[string][]languageProtoRule
+	// unsupportedKinds maps proto_library names to the kinds of the
+	// unsupported language rules (e.g. java_proto_library) referencing them.
+	unsupportedKinds map[string][]string
+	// unsupportedRules holds the rules related to proto_library rules that
+	// pbsync doesn't know how to sync.
+	unsupportedRules []unsupportedRule
+	// externalProtoRefs holds the labels of the language rules referencing
+	// a proto_library in another package, which aren't synced.
+	externalProtoRefs []string
+	directives        *directives
 }
 
-func (b *parsedBuildFile) getLangProtoRulesForProto(protoFile string) ([]languageProtoRule, bool) {
-	basename := filepath.Base(protoFile)
-	protoRule, ok := b.protoFileToRule[basename]
+// protoKey returns the key of protoFile in parsedBuildFile.protoFileToRule.
+func protoKey(workspaceRoot, protoFile string) string {
+	rel, err := filepath.Rel(workspaceRoot, protoFile)
+	if err != nil {
+		return protoFile
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (b *parsedBuildFile) getLangProtoRulesForProto(workspaceRoot, protoFile string) ([]languageProtoRule, bool) {
+	protoRule, ok := b.protoFileToRule[protoKey(workspaceRoot, protoFile)]
 	if !ok {
 		return nil, false
 	}
@@ -172,8 +433,8 @@ func (b *parsedBuildFile) getLangProtoRulesForProto(protoFile string) ([]languag
 	return langRules, true
 }
 
-func parseBuildFile(buildFilePath string) (*parsedBuildFile, error) {
-	buildFileContents, err := ioutil.ReadFile(buildFilePath)
+func parseBuildFile(workspaceRoot, buildFilePath string) (*parsedBuildFile, error) {
+	buildFileContents, err := ioutil.ReadFile(fsPath(buildFilePath))
 	if err != nil {
 		return nil, err
 	}
@@ -181,29 +442,70 @@ func parseBuildFile(buildFilePath string) (*parsedBuildFile, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not parse BUILD file %q: %v", buildFilePath, err)
 	}
+	directives, err := parseDirectives(buildFilePath, buildFileContents)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := protoKey(workspaceRoot, filepath.Dir(buildFilePath))
+	if pkg == "." {
+		pkg = ""
+	}
 
 	protoFileToRule := make(map[string]string)
+	protoRuleNames := make(map[string]bool)
 
 	protoRules := buildFile.Rules("proto_library")
 	for _, r := range protoRules {
+		protoRuleNames[r.Name()] = true
 		srcs := r.AttrStrings("srcs")
 		if srcs == nil {
 			return nil, fmt.Errorf("%s: proto rule %q does not have have srcs", buildFilePath, r.Name())
 		}
 		for _, src := range srcs {
-			src = strings.TrimPrefix(src, ":")
-			if protoFileToRule[src] != "" {
+			l, err := parseLabel(src, pkg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: proto rule %q: %s", buildFilePath, r.Name(), err)
+			}
+			if l.repo != "" {
+				// Protos from other repositories aren't synced.
+				continue
+			}
+			key := path.Join(l.pkg, l.name)
+			if protoFileToRule[key] != "" {
 				return nil, fmt.Errorf("%s: src file %q appears in multiple proto rules", buildFilePath, src)
 			}
-			protoFileToRule[src] = r.Name()
+			protoFileToRule[key] = r.Name()
 		}
 	}
 
 	protoRuleToLangProtoRules := make(map[string][]languageProtoRule)
+	unsupportedKinds := make(map[string][]string)
+	var unsupportedRules []unsupportedRule
+	var externalProtoRefs []string
+	// embeddedBy maps go_proto_library names to the names of the rules
+	// embedding them.
+	embeddedBy := make(map[string][]string)
 
 	goProtoRules := buildFile.Rules("")
 	for _, r := range goProtoRules {
+		if _, ok := protoGRPCRules[r.Kind()]; ok {
+			langProtoRules, err := parseProtoGRPCRule(buildFilePath, pkg, r)
+			if err != nil {
+				return nil, err
+			}
+			for _, lr := range langProtoRules {
+				protoRuleToLangProtoRules[lr.protoRuleName] = append(protoRuleToLangProtoRules[lr.protoRuleName], lr)
+			}
+			continue
+		}
 		if r.Kind() != goProtoLibrary && r.Kind() != tsProtoLibrary {
+			if refs := protoRuleRefs(r, protoRuleNames); isProtoAdjacent(r, refs) {
+				unsupportedRules = append(unsupportedRules, unsupportedRule{kind: r.Kind(), label: "//" + pkg + ":" + r.Name()})
+				for _, ref := range refs {
+					unsupportedKinds[ref] = append(unsupportedKinds[ref], r.Kind())
+				}
+			}
 			continue
 		}
 
@@ -212,7 +514,7 @@ func parseBuildFile(buildFilePath string) (*parsedBuildFile, error) {
 			return nil, fmt.Errorf("%s: go proto rule %q missing proto attribute", buildFilePath, r.Name())
 		}
 		if !strings.HasPrefix(protoRule, ":") {
-			// fmt.Printf("%s: go proto rule %q has unsupported proto reference: %s\n", buildFilePath, r.Name(), protoRule)
+			externalProtoRefs = append(externalProtoRefs, "//"+pkg+":"+r.Name())
 			continue
 		}
 
@@ -224,100 +526,319 @@ func parseBuildFile(buildFilePath string) (*parsedBuildFile, error) {
 			}
 		}
 
+		dest, err := ruleDest(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %q: %s", buildFilePath, r.Name(), err)
+		}
+
+		var outs []string
+		if r.Kind() == tsProtoLibrary {
+			// Macros may name the outputs explicitly instead of deriving
+			// <name>.d.ts.
+			for _, attr := range tsOutputAttrs {
+				if out := strings.TrimPrefix(r.AttrString(attr), ":"); out != "" {
+					outs = append(outs, out)
+				}
+			}
+		}
+
 		protoRuleName := protoRule[1:]
 		langProtoRule := languageProtoRule{
 			kind:          r.Kind(),
 			name:          r.Name(),
 			protoRuleName: protoRule[1:],
 			importPath:    importPath,
+			pkg:           pkg,
+			dest:          dest,
+			outs:          outs,
 		}
 		protoRuleToLangProtoRules[protoRuleName] = append(protoRuleToLangProtoRules[protoRuleName], langProtoRule)
+		if r.Kind() == goProtoLibrary {
+			for _, e := range r.AttrStrings("embed") {
+				if strings.HasPrefix(e, ":") {
+					embeddedBy[e[1:]] = append(embeddedBy[e[1:]], r.Name())
+				}
+			}
+		}
 	}
+	resolveGoRules(protoFileToRule, protoRuleToLangProtoRules, embeddedBy)
 
 	return &parsedBuildFile{
 		protoFileToRule:           protoFileToRule,
 		protoRuleToLangProtoRules: protoRuleToLangProtoRules,
+		unsupportedKinds:          unsupportedKinds,
+		unsupportedRules:          unsupportedRules,
+		externalProtoRefs:         externalProtoRefs,
+		directives:                directives,
 	}, nil
 }
 
+// resolveGoRules sets the protos, pkgProtos and embedders of the
+// go_proto_library rules.
+func resolveGoRules(protoFileToRule map[string]string, protoRuleToLangProtoRules map[string][]languageProtoRule, embeddedBy map[string][]string) {
+	// Map each go rule to the base names of its protos.
+	protoBases := map[string][]string{}
+	var pkgProtos []string
+	for key, protoRule := range protoFileToRule {
+		base := strings.TrimSuffix(path.Base(key), ".proto")
+		for _, lr := range protoRuleToLangProtoRules[protoRule] {
+			if lr.kind == goProtoLibrary {
+				protoBases[lr.name] = append(protoBases[lr.name], base)
+				pkgProtos = append(pkgProtos, base)
+			}
+		}
+	}
+	for _, langRules := range protoRuleToLangProtoRules {
+		for i := range langRules {
+			if langRules[i].kind != goProtoLibrary {
+				continue
+			}
+			langRules[i].protos = protoBases[langRules[i].name]
+			langRules[i].pkgProtos = pkgProtos
+			langRules[i].embedders = transitiveEmbedders(embeddedBy, langRules[i].name)
+		}
+	}
+}
+
+// generatedFrom returns the base name of the proto (out of protoBases) that
+// the generated Go file name was generated from, e.g. "foo" for
+// foo_grpc.pb.go, or "". If several bases match, such as "user" and
+// "user_service" for user_service.pb.go, the longest one is returned.
+func generatedFrom(name string, protoBases []string) string {
+	res := ""
+	for _, b := range protoBases {
+		if len(b) > len(res) && (strings.HasPrefix(name, b+".") || strings.HasPrefix(name, b+"_")) {
+			res = b
+		}
+	}
+	return res
+}
+
+// transitiveEmbedders returns the names of the rules that embed the rule
+// name, directly or through other embedded rules.
+func transitiveEmbedders(embeddedBy map[string][]string, name string) []string {
+	var res []string
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, e := range embeddedBy[n] {
+			if !seen[e] {
+				seen[e] = true
+				res = append(res, e)
+				queue = append(queue, e)
+			}
+		}
+	}
+	return res
+}
+
 type result struct {
 	created  int64
 	upToDate int64
+	// protos is the number of protos considered.
+	protos int64
+
+	mu sync.Mutex
+	// unsupported maps unsupported rule kinds to the labels of the rules
+	// of that kind seen.
+	unsupported map[string]map[string]bool
+	// skipped maps skip reasons to the protos (or rules) skipped for them.
+	skipped map[string]map[string]bool
+	// missing holds the protos that have no generated sources to sync,
+	// either because no rule maps them or because their outputs haven't
+	// been built.
+	missing []string
+	// updated holds the destination files that were written.
+	updated []string
+	// findings holds the problems found in check mode.
+	findings []finding
+
+	// stale holds the labels of rules whose outputs are older than their
+	// protos.
+	stale map[string]bool
+	// kinds counts the generated files found per language rule kind.
+	kinds map[string]int64
+
+	// workspaceRoot is the root of the synced workspace.
+	workspaceRoot string
+	// outputRoot is the directory destinations are written under, if not
+	// the workspace root (see destRoot).
+	outputRoot string
+	// config is the workspace config, if any.
+	config *config
+
+	// eol maps destinations to their eol attribute in .gitattributes, when
+	// the config's newlines policy follows it.
+	eol map[string]string
+
+	// actions holds the planned copies of generated files.
+	actions []*syncAction
+	// outputGroupQueries maps rule labels to the *outputGroupQuery of
+	// their output groups.
+	outputGroupQueries sync.Map
+	// buildFiles is the number of BUILD files parsed to plan the actions.
+	buildFiles int
+	// planning is how long planning the actions took.
+	planning time.Duration
+	// pending holds the writes deferred to the --output-patch file.
+	pending []pendingWrite
+	// churn records the files and bytes written, for the history.
+	churn *historyRun
+	// newFiles holds the destination files that were newly created.
+	newFiles []string
+
+	// manifest is the workspace manifest, updated as files are synced.
+	manifest    *manifest
+	protoHashes map[string]string
 }
 
-func syncProto(workspaceRoot string, protoFile string, buildFile *parsedBuildFile, result *result) error {
-	rules, ok := buildFile.getLangProtoRulesForProto(protoFile)
-	if !ok {
-		fmt.Printf("could not figure out proto rule for %q\n", protoFile)
-		return nil
+func (r *result) addMissing(protoFile string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.missing {
+		if p == protoFile {
+			return
+		}
+	}
+	r.missing = append(r.missing, protoFile)
+}
+
+func (r *result) addUpdated(dest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updated = append(r.updated, dest)
+}
+
+func (r *result) countKind(kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.kinds == nil {
+		r.kinds = map[string]int64{}
 	}
+	r.kinds[kind]++
+}
 
-	bazelBin, err := getBazelBinDir(workspaceRoot)
-	if err != nil {
-		return fmt.Errorf("failed to determine bazel bin dir: %s", err)
+var errNotGenerated = errors.New("generated file not found")
+
+// fileLanguage returns the language of a generated file, as used in config
+// settings, based on its extension.
+func fileLanguage(path string) string {
+	switch ext := filepath.Ext(path); ext {
+	case ".ts", ".js":
+		return "ts"
+	default:
+		return strings.TrimPrefix(ext, ".")
 	}
+}
 
-	for _, rule := range rules {
-		srcAndDestPaths, err := rule.getSrcAndDest(workspaceRoot, bazelBin, protoFile)
+// syncFile copies the generated file src to dest, unless dest is already up
+// to date. protoFile is the proto that src was generated from.
+func syncFile(protoFile, src, dest string, result *result) error {
+	if pattern := result.protectedBy(dest); pattern != "" {
+		return fmt.Errorf("refusing to write %s (generated from %s), which matches the protected pattern %q", dest, protoFile, pattern)
+	}
+	// Read the generated source
+	sb, err := readGenerated(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Skip; the generated source is not available.
+			debugf("%s: generated file %s not found", protoFile, src)
+			return errNotGenerated
+		}
+		return err
+	}
+	throttle(len(sb))
+	if len(sb) == 0 {
+		switch result.config.emptyFilePolicy(fileLanguage(src)) {
+		case emptyFileSkip:
+			debugf("%s: skipping empty generated file %s", protoFile, src)
+			return nil
+		case emptyFileError:
+			return fmt.Errorf("file is unexpectedly empty: %s (generated from %s)", src, protoFile)
+		}
+	}
 
-		for _, srcAndDest := range srcAndDestPaths {
-			src := srcAndDest.src
-			dest := srcAndDest.dest
+	// Read the existing target file
+	db, err := os.ReadFile(fsPath(dest))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	throttle(len(db))
+	destExists := err == nil
+	cloneSrc := src
+	if normalized := result.normalizeNewlines(dest, sb, db, destExists); !bytes.Equal(normalized, sb) {
+		sb = normalized
+		cloneSrc = ""
+	}
 
-			if err != nil {
-				return err
-			}
+	if checkMode {
+		checkFile(protoFile, dest, sb, db, destExists, result)
+		return nil
+	}
+	if auditMode {
+		auditFile(protoFile, dest, sb, db, destExists, result)
+		return nil
+	}
 
-			// Read the generated source
-			sb, err := os.ReadFile(src)
-			if err != nil {
-				if os.IsNotExist(err) {
-					// Skip; the generated source is not available.
-					continue
-				}
-				return err
-			}
-			sourceContent := string(sb)
-			if sourceContent == "" {
-				return fmt.Errorf("file is unexpectedly empty: %s", protoFile)
-			}
+	if destExists && result.config.sameContents(sb, db) {
+		debugf("%s: %s is up to date", protoFile, dest)
+		atomic.AddInt64(&result.upToDate, 1)
+		result.recordSynced(protoFile, dest, sb)
+		return nil
+	}
 
-			// Read the existing target file
-			db, err := os.ReadFile(dest)
-			if err != nil && !os.IsNotExist(err) {
-				return err
-			}
-			destContent := string(db)
+	if *failOnClobber && destExists && !result.owns(dest) {
+		return fmt.Errorf("refusing to overwrite %s, which pbsync didn't write and differs from the file generated from %s (--fail-on-clobber); remove it to have it synced", dest, protoFile)
+	}
 
-			if sourceContent == destContent {
-				atomic.AddInt64(&result.upToDate, 1)
-				continue
-			}
+	if patchMode() {
+		debugf("%s: %s would be updated from %s", protoFile, dest, src)
+		atomic.AddInt64(&result.created, 1)
+		result.addPending(dest, sb)
+		return nil
+	}
 
-			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
-				return err
-			}
-			if err := os.WriteFile(dest, sb, 0644); err != nil {
-				return err
-			}
-			atomic.AddInt64(&result.created, 1)
+	throttle(len(sb))
+	if err := writeDest(cloneSrc, dest, sb); err != nil {
+		return err
+	}
+	if *verifyWrites {
+		if err := verifyWrite(dest, sb); err != nil {
+			return err
 		}
 	}
+	debugf("%s: updated %s from %s", protoFile, dest, src)
+	atomic.AddInt64(&result.created, 1)
+	result.addUpdated(dest)
+	result.recordChurn(protoFile, dest, len(sb))
+	if !destExists {
+		result.addNewFile(dest)
+	}
+	result.recordSynced(protoFile, dest, sb)
 	return nil
 }
 
-func copyGeneratedProtos(workspaceRoot string) (*result, error) {
-	_, err := os.Stat(filepath.Join(workspaceRoot, "WORKSPACE"))
+// listProtos returns the workspace-relative paths of all .proto files in the
+// workspace.
+func listProtos(workspaceRoot string) ([]string, error) {
+	// Get proto source paths (use the git index for speed)
+	paths, err := gitListFiles(workspaceRoot, "*.proto")
 	if err != nil {
-		return nil, fmt.Errorf("%q does not appear to be a Bazel workspace (no WORKSPACE file): %s", workspaceRoot, err)
+		return nil, fmt.Errorf("failed to list proto sources: %s", err)
 	}
+	return paths, nil
+}
 
-	// Get proto source paths (use the git index for speed)
-	var protos []string
+// gitListFiles returns the workspace-relative paths of the tracked and
+// untracked (but not ignored) files matching the given pathspec. If the
+// workspace is not a git repo, it returns no paths.
+func gitListFiles(workspaceRoot, pathspec string) ([]string, error) {
 	lsFiles := exec.Command("sh", "-c", `
-		git ls-files --exclude-standard '*.proto'
-		git ls-files --others --exclude-standard '*.proto'
-	`)
+		git ls-files --exclude-standard "$1"
+		git ls-files --others --exclude-standard "$1"
+	`, "sh", pathspec)
 	lsFiles.Dir = workspaceRoot
 	stderr := &bytes.Buffer{}
 	lsFiles.Stderr = stderr
@@ -326,41 +847,253 @@ func copyGeneratedProtos(workspaceRoot string) (*result, error) {
 	if err := lsFiles.Run(); err != nil {
 		// If we're not in a git repo, do nothing.
 		if _, err := os.Stat(filepath.Join(workspaceRoot, ".git")); os.IsNotExist(err) {
-			return &result{}, nil
+			return nil, nil
 		}
-		return nil, fmt.Errorf("failed to list proto sources: git ls-files failed: %s", stderr.String())
+		return nil, fmt.Errorf("git ls-files failed: %s", stderr.String())
 	}
+	var paths []string
 	for _, path := range strings.Split(buf.String(), "\n") {
-		protos = append(protos, filepath.Join(workspaceRoot, path))
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// readProtoList reads a list of proto paths, one per line, from the given
+// file ("-" meaning stdin).
+func readProtoList(path string) ([]string, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(fsPath(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var protos []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		protos = append(protos, line)
+	}
+	return protos, nil
+}
+
+// resolveProtos returns the absolute paths of the given protos. If protoList
+// is nil, it returns the protos in scope for the workspace: all of them, or
+// only the changed ones with --changed.
+func resolveProtos(workspaceRoot string, protoList []string) ([]string, error) {
+	if protoList == nil && *changedOnly {
+		changed, err := changedProtos(workspaceRoot)
+		if err != nil {
+			return nil, err
+		}
+		// Protos importing a changed proto are regenerated too.
+		protoList, err = withReverseDeps(workspaceRoot, changed)
+		if err != nil {
+			return nil, err
+		}
+	} else if protoList == nil && *protoQuery != "" {
+		var err error
+		protoList, err = queryProtos(workspaceRoot)
+		if err != nil {
+			return nil, err
+		}
+	} else if protoList == nil {
+		var err error
+		protoList, err = listProtos(workspaceRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var protos []string
+	for _, path := range protoList {
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workspaceRoot, path)
+		}
+		protos = append(protos, path)
+	}
+	return filterHere(protos), nil
+}
+
+// copyGeneratedProtos syncs the generated sources for the given protos, or
+// for all protos in the workspace if protoList is nil.
+func copyGeneratedProtos(ctx context.Context, workspaceRoot string, protoList []string) (*result, error) {
+	if !isWorkspaceRoot(workspaceRoot) {
+		return nil, fmt.Errorf("%q does not appear to be a Bazel workspace (no WORKSPACE or MODULE.bazel file)", workspaceRoot)
+	}
+
+	unlock, err := lockWorkspace(workspaceRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock workspace: %s", err)
+	}
+	defer unlock()
+
+	cfg, err := loadConfig(workspaceRoot)
+	if err != nil {
+		return nil, err
 	}
 
-	result := &result{}
+	if err := awaitBazel(ctx, workspaceRoot); err != nil {
+		return nil, err
+	}
+
+	planStart := time.Now()
+	protos, err := resolveProtos(workspaceRoot, protoList)
+	if err != nil {
+		return nil, err
+	}
+	debugf("%s: syncing %d protos", workspaceRoot, len(protos))
 
+	outputRoot, err := cfg.outputRoot(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	result := &result{protos: int64(len(protos)), config: cfg, workspaceRoot: workspaceRoot, outputRoot: outputRoot}
+	result.manifest, err = loadManifest(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	if checkMode && len(result.manifest.Files) == 0 {
+		// E.g. on a fresh CI machine, or after the bazel configuration
+		// changed.
+		warnf("%s: no record of past syncs on this machine, so outputs built from older protos can't be detected", workspaceRoot)
+	}
+
+	if *generator != "" && !mapMode && !estimateMode && !hasBazelBin(workspaceRoot) {
+		if err := generateProtos(workspaceRoot, protos, result); err != nil {
+			return nil, err
+		}
+		if patchMode() {
+			if err := writePatch(*outputPatch, outputRoot, result); err != nil {
+				return nil, fmt.Errorf("failed to write patch: %s", err)
+			}
+		}
+		return result, nil
+	}
+
+	if len(protos) > 0 {
+		// Reads and stats of bazel-bin are subject to --read-timeout
+		// from here on if it is on a FUSE filesystem.
+		bazelBinFilesystem(workspaceRoot)
+	}
 	eg := errgroup.Group{}
-	parser := newBuildFileParser()
+	buildFiles := newBuildFileIndex(workspaceRoot)
 
+	var mu sync.Mutex
+	var actions []*syncAction
 	for _, proto := range protos {
 		proto := proto
 		eg.Go(func() error {
-			// For now only support build files named "BUILD".
-			buildFilePath := filepath.Join(filepath.Dir(proto), "BUILD")
-			buildFile, err := parser.Parse(buildFilePath)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			buildFile, err := buildFiles.find(proto)
 			if err != nil {
 				// Ignore protos that aren't direct children of Bazel packages.
 				if os.IsNotExist(err) {
+					result.addMissing(proto)
+					result.addSkippedProto(workspaceRoot, skipNoBuildFile, proto)
 					return nil
 				}
-				return fmt.Errorf("failed to parse BUILD file at %q: %v", buildFilePath, err)
+				return err
 			}
-			if err := syncProto(workspaceRoot, proto, buildFile, result); err != nil {
+			protoActions, err := planProto(workspaceRoot, proto, buildFile, result)
+			if err != nil {
 				return err
 			}
+			mu.Lock()
+			actions = append(actions, protoActions...)
+			mu.Unlock()
 			return nil
 		})
 	}
 	if err := eg.Wait(); err != nil {
 		return nil, err
 	}
+	// External rules aren't associated with any workspace protos, so only
+	// sync them when syncing the whole workspace.
+	if protoList == nil && !*changedOnly && !*here && *protoQuery == "" {
+		externalActions, err := planExternalRules(workspaceRoot, cfg)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, externalActions...)
+	}
+	rebaseActions(workspaceRoot, outputRoot, actions)
+	actions, err = checkCollisions(actions)
+	if err != nil {
+		return nil, err
+	}
+	result.actions = actions
+	result.buildFiles = buildFiles.parser.parsed()
+	result.planning = time.Since(planStart)
+	if mapMode || estimateMode {
+		return result, nil
+	}
+	if cfg.newlinePolicy() == newlinesGitattributes {
+		if err := loadEOLAttributes(outputRoot, actions, result); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyActions(ctx, workspaceRoot, actions, result); err != nil {
+		if ctx.Err() != nil {
+			// Record the files synced before the interruption.
+			if err := saveManifest(workspaceRoot, result.manifest); err != nil {
+				debugf("failed to save manifest: %s", err)
+			}
+			return result, ctx.Err()
+		}
+		return nil, err
+	}
+	if *goDest == vendorGoDest && !checkMode && !auditMode && !patchMode() {
+		if err := updateVendorModules(outputRoot, actions); err != nil {
+			return nil, fmt.Errorf("failed to update vendor/modules.txt: %s", err)
+		}
+	}
+	if *generator == protocGenerator {
+		if err := generateProtos(workspaceRoot, result.missing, result); err != nil {
+			return nil, err
+		}
+	}
+	if checkMode {
+		checkMissing(result)
+		return result, nil
+	}
+	if auditMode {
+		return result, nil
+	}
+	if patchMode() {
+		if err := writePatch(*outputPatch, outputRoot, result); err != nil {
+			return nil, fmt.Errorf("failed to write patch: %s", err)
+		}
+		return result, nil
+	}
+	if err := updateBuildFiles(outputRoot, result); err != nil {
+		return nil, err
+	}
+	if cfg.GoWork {
+		if err := updateGoWork(workspaceRoot, outputRoot, actions); err != nil {
+			return nil, fmt.Errorf("failed to update go.work: %s", err)
+		}
+	}
+	if err := saveManifest(workspaceRoot, result.manifest); err != nil {
+		return nil, fmt.Errorf("failed to save manifest: %s", err)
+	}
+	if err := appendHistory(workspaceRoot, result); err != nil {
+		warnf("failed to record history: %s", err)
+	}
+	if err := checkFreshness(result); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
@@ -371,16 +1104,31 @@ type Result[T any] struct {
 
 // buildFileParser is a deduplicating, concurrency-safe BUILD file parser.
 type buildFileParser struct {
-	group singleflight.Group
+	workspaceRoot string
+	group         singleflight.Group
 
 	mu    sync.RWMutex
 	cache map[string]*Result[*parsedBuildFile]
 }
 
-func newBuildFileParser() *buildFileParser {
+func newBuildFileParser(workspaceRoot string) *buildFileParser {
 	return &buildFileParser{
-		cache: map[string]*Result[*parsedBuildFile]{},
+		workspaceRoot: workspaceRoot,
+		cache:         map[string]*Result[*parsedBuildFile]{},
+	}
+}
+
+// parsed returns the number of BUILD files parsed successfully.
+func (p *buildFileParser) parsed() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	n := 0
+	for _, r := range p.cache {
+		if r.Err == nil {
+			n++
+		}
 	}
+	return n
 }
 
 func (p *buildFileParser) Parse(path string) (*parsedBuildFile, error) {
@@ -405,7 +1153,7 @@ func (p *buildFileParser) Parse(path string) (*parsedBuildFile, error) {
 			p.mu.Unlock()
 		}()
 
-		return parseBuildFile(path)
+		return parseBuildFile(p.workspaceRoot, path)
 	})
 
 	if err != nil {
@@ -415,7 +1163,12 @@ func (p *buildFileParser) Parse(path string) (*parsedBuildFile, error) {
 }
 
 func printf(msg string, args ...any) {
-	fmt.Fprintf(os.Stderr, msg, args...)
+	writeOutput([]byte(fmt.Sprintf(msg, args...)))
+	debugf(strings.TrimSuffix(msg, "\n"), args...)
+}
+
+func warnf(msg string, args ...any) {
+	printf("pbsync: warning: "+msg+"\n", args...)
 }
 
 func fatalf(msg string, args ...any) {
@@ -423,35 +1176,255 @@ func fatalf(msg string, args ...any) {
 	os.Exit(1)
 }
 
+// parseCommand splits the subcommand, if any, from the command line args.
+func parseCommand(args []string) (command string, rest []string, err error) {
+	if len(args) > 0 && (args[0] == checkCommand || args[0] == commitCommand || args[0] == mapCommand || args[0] == initCommand || args[0] == auditCommand || args[0] == estimateCommand) {
+		return args[0], args[1:], nil
+	}
+	if len(args) > 0 && args[0] == "stats" {
+		if len(args) < 2 || args[1] != "history" {
+			return "", nil, fmt.Errorf("usage: pbsync stats history [flags] [workspace ...]")
+		}
+		return statsHistoryCommand, args[2:], nil
+	}
+	if len(args) == 0 || args[0] != "bsr" {
+		return "", args, nil
+	}
+	if len(args) < 2 || (args[1] != "push" && args[1] != "pull") {
+		return "", nil, fmt.Errorf("usage: pbsync bsr push|pull [flags] [workspace ...]")
+	}
+	return "bsr " + args[1], args[2:], nil
+}
+
 func main() {
 	start := time.Now()
 
-	flag.Parse()
+	command, args, err := parseCommand(os.Args[1:])
+	if err != nil {
+		fatalf("%s", err)
+	}
+	flag.CommandLine.Parse(args)
+	checkMode = command == checkCommand
+	mapMode = command == mapCommand
+	auditMode = command == auditCommand
+	estimateMode = command == estimateCommand
 
-	dirs := flag.Args()
-	if len(dirs) == 0 {
-		cwd, err := os.Getwd()
+	dirs, err := workspaceRoots(flag.Args())
+	if err != nil {
+		fatalf("%s", err)
+	}
+	// Profiles are read from the config of the first workspace.
+	set, err := applyProfile(dirs[0])
+	if err != nil {
+		fatalf("%s", err)
+	}
+	if set["workspace"] || set["recursive"] {
+		// The profile changes which workspaces are synced.
+		dirs, err = workspaceRoots(flag.Args())
 		if err != nil {
-			fatalf("failed to determine working dir: %s", err)
+			fatalf("%s", err)
 		}
-		dirs = append(dirs, cwd)
 	}
 
-	total := &result{}
+	closeLog, err := openLog()
+	if err != nil {
+		fatalf("failed to open log file: %s", err)
+	}
+	defer closeLog()
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if err := validateFSMode(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateGenerator(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateGoDest(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateVerify(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateQuery(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateOnlyRules(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateGazelle(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateReport(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateResultFile(command); err != nil {
+		fatalf("%s", err)
+	}
+	if err := setNice(); err != nil {
+		fatalf("%s", err)
+	}
+	summaryTemplate, err := parseSummaryTemplate()
+	if err != nil {
+		fatalf("invalid --summary template: %s", err)
+	}
+
+	if err := validateHere(dirs); err != nil {
+		fatalf("%s", err)
+	}
+	if err := validateOutputPatch(command, dirs); err != nil {
+		fatalf("%s", err)
+	}
+	if err := clearCache(dirs); err != nil {
+		fatalf("failed to clear cache: %s", err)
+	}
 
-	for _, dir := range dirs {
-		result, err := copyGeneratedProtos(dir)
+	var protoList []string
+	if *protoListFile != "" {
+		list, err := readProtoList(*protoListFile)
 		if err != nil {
-			fatalf("failed to sync protos for workspace %s: %s", dir, err)
+			fatalf("failed to read proto list: %s", err)
 		}
-		total.created += result.created
-		total.upToDate += result.upToDate
+		// Distinguish an empty list from "not specified".
+		protoList = append([]string{}, list...)
 	}
-	if total.created > 0 {
-		printf("🔄 ")
-	} else {
-		printf("\x1b[90m")
+
+	if command == initCommand {
+		for _, dir := range dirs {
+			if err := initWorkspace(dir); err != nil {
+				fatalf("failed to initialize workspace %s: %s", dir, err)
+			}
+		}
+		return
+	}
+
+	if command == statsHistoryCommand {
+		for _, dir := range dirs {
+			if err := printHistory(dir); err != nil {
+				fatalf("failed to read history for workspace %s: %s", dir, err)
+			}
+		}
+		return
+	}
+
+	if command == bsrPushCommand {
+		for _, dir := range dirs {
+			if err := bsrPush(dir, protoList); err != nil {
+				fatalf("failed to push protos for workspace %s: %s", dir, err)
+			}
+		}
+		return
 	}
 
-	printf("pbsync: updated: %d, up to date: %d, duration: %s\x1b[m\n", total.created, total.upToDate, time.Since(start))
+	sync := copyGeneratedProtos
+	if command == bsrPullCommand {
+		sync = bsrPull
+	}
+	if *debounce > 0 {
+		sync = debounced(sync)
+	}
+
+	total := &result{}
+	failed := 0
+	workspaces := syncWorkspaces(ctx, dirs, protoList, sync)
+	reportTelemetry(command, start, workspaces)
+	usage := measureResources(workspaces)
+	if ctx.Err() != nil {
+		reportInterrupted(workspaces)
+	}
+	for _, ws := range workspaces {
+		if ws.err != nil {
+			failed++
+			printf("pbsync: %s: %s\n", ws.dir, ws.err)
+			continue
+		}
+		if len(dirs) > 1 && !checkMode && !mapMode && !auditMode && !estimateMode && !*quiet {
+			printf("pbsync: %s: updated: %d, up to date: %d\n", ws.dir, ws.result.created, ws.result.upToDate)
+		}
+		total.created += ws.result.created
+		total.upToDate += ws.result.upToDate
+		total.findings = append(total.findings, ws.result.findings...)
+		prefix := ""
+		if len(dirs) > 1 {
+			prefix = ws.dir
+		}
+		total.mergeSkipped(prefix, ws.result)
+		for kind, labels := range ws.result.unsupported {
+			for l := range labels {
+				total.addUnsupported([]unsupportedRule{{kind: kind, label: l}})
+			}
+		}
+		if command == commitCommand {
+			if err := commitGenerated(ws.dir, ws.result); err != nil {
+				failed++
+				printf("pbsync: %s: failed to commit generated files: %s\n", ws.dir, err)
+			}
+		}
+	}
+	if *listUnsupported {
+		printUnsupported(total.unsupported)
+	}
+	if mapMode {
+		if err := writeGeneratedMap(workspaces); err != nil {
+			fatalf("failed to write generated-files map: %s", err)
+		}
+		if failed > 0 {
+			fatalf("failed to map %d of %d workspace(s)", failed, len(dirs))
+		}
+		return
+	}
+	if estimateMode {
+		for _, ws := range workspaces {
+			if ws.err == nil {
+				printEstimate(ws.dir, estimateSync(ws.result))
+			}
+		}
+		if failed > 0 {
+			fatalf("failed to estimate %d of %d workspace(s)", failed, len(dirs))
+		}
+		return
+	}
+	if checkMode {
+		if err := writeReport(workspaces); err != nil {
+			fatalf("failed to write report: %s", err)
+		}
+		n := printFindings(total.findings)
+		if n > 0 || failed > 0 {
+			fatalf("check: %d problem(s) found, %d workspace(s) failed", n, failed)
+		}
+		if !*quiet {
+			printf("pbsync: check: all generated files are up to date\n")
+		}
+		return
+	}
+	if auditMode {
+		n := printFindings(total.findings)
+		if n > 0 || failed > 0 {
+			fatalf("audit: %d hand-edited file(s) found, %d workspace(s) failed", n, failed)
+		}
+		if !*quiet {
+			printf("pbsync: audit: no hand-edited generated files found\n")
+		}
+		return
+	}
+	if err := writeResultFile(start, workspaces, total, failed, usage); err != nil {
+		warnf("failed to write result file: %s", err)
+	}
+	notifyResult(total.created, failed, len(dirs))
+	defer func() {
+		if failed > 0 {
+			fatalf("failed to sync %d of %d workspace(s)", failed, len(dirs))
+		}
+	}()
+	s := &summary{
+		Updated:  total.created,
+		UpToDate: total.upToDate,
+		Duration: time.Since(start),
+	}
+	if !*quiet {
+		printSkipped(total.skipped)
+	}
+	if err := printSummary(summaryTemplate, s); err != nil {
+		fatalf("failed to print summary: %s", err)
+	}
 }
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestGeneratedFrom(t *testing.T) {
+	bases := []string{"user", "user_service", "api"}
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"user.pb.go", "user"},
+		{"user_grpc.pb.go", "user"},
+		{"user_service.pb.go", "user_service"},
+		{"user_service_grpc.pb.go", "user_service"},
+		{"user_service.pb.gw.go", "user_service"},
+		{"api.pb.validate.go", "api"},
+		{"apis.pb.go", ""},
+		{"other.pb.go", ""},
+	} {
+		if got := generatedFrom(tc.name, bases); got != tc.want {
+			t.Errorf("generatedFrom(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
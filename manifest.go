@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	manifestKey = "manifest"
+	ownedKey    = "owned"
+)
+
+var (
+	failOnClobber = flag.Bool("fail-on-clobber", false, "Fail instead of overwriting existing files that pbsync didn't write before and that differ from the generated files, e.g. hand-maintained forks of generated code.")
+)
+
+// manifest records what pbsync last synced to each destination in a
+// workspace. It is stored in the user cache dir.
+type manifest struct {
+	// BazelConfig is the fingerprint of the bazel configuration the files
+	// were generated with (see bazelConfigFingerprint).
+	BazelConfig string `json:"bazel_config"`
+	// Files maps destination paths to what was last synced there.
+	Files map[string]*manifestEntry `json:"files"`
+
+	// owned holds the destinations pbsync has written, for
+	// --fail-on-clobber. Unlike Files, it is kept when the bazel
+	// configuration changes and by --cache-clear, so it is stored in a
+	// separate file.
+	owned map[string]bool
+}
+
+type manifestEntry struct {
+	// Proto is the path of the .proto the file was generated from.
+	Proto string `json:"proto"`
+	// ProtoHash is the hash of Proto's contents when the file was synced.
+	ProtoHash string `json:"proto_hash"`
+	// Hash is the hash of the synced file's contents, normalized according
+	// to the compare policy (see comparisonHash).
+	Hash string `json:"hash"`
+}
+
+func contentHash(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+func manifestPath(workspaceRoot string) (string, error) {
+	path, err := cachePath(cacheKey(manifestKey, workspaceRoot))
+	if err != nil {
+		return "", err
+	}
+	return path + ".json", nil
+}
+
+func ownedPath(workspaceRoot string) (string, error) {
+	path, err := cachePath(cacheKey(ownedKey, workspaceRoot))
+	if err != nil {
+		return "", err
+	}
+	return path + ".json", nil
+}
+
+// loadManifest returns the workspace's manifest, which is empty if nothing
+// has been synced yet.
+func loadManifest(workspaceRoot string) (*manifest, error) {
+	owned, err := loadOwned(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := bazelConfigFingerprint(workspaceRoot)
+	m := &manifest{BazelConfig: fingerprint, Files: map[string]*manifestEntry{}}
+	path, err := manifestPath(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(fsPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			m.owned = owned
+			return m, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		// The manifest is only a cache of past syncs; start over rather
+		// than failing.
+		warnf("ignoring corrupt manifest %s: %s", path, err)
+		m = &manifest{BazelConfig: fingerprint, Files: map[string]*manifestEntry{}}
+	}
+	if m.BazelConfig != fingerprint {
+		// The outputs recorded in the manifest may have been generated
+		// differently.
+		debugf("%s: bazel configuration changed; discarding manifest", workspaceRoot)
+		m = &manifest{BazelConfig: fingerprint, Files: map[string]*manifestEntry{}}
+	}
+	if m.Files == nil {
+		m.Files = map[string]*manifestEntry{}
+	}
+	for dest := range m.Files {
+		// Manifests written before ownership was recorded separately.
+		owned[dest] = true
+	}
+	m.owned = owned
+	return m, nil
+}
+
+// loadOwned returns the destinations pbsync has written in the workspace.
+func loadOwned(workspaceRoot string) (map[string]bool, error) {
+	owned := map[string]bool{}
+	path, err := ownedPath(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(fsPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return owned, nil
+		}
+		return nil, err
+	}
+	var files []string
+	if err := json.Unmarshal(b, &files); err != nil {
+		warnf("ignoring corrupt record of written files %s: %s", path, err)
+		return owned, nil
+	}
+	for _, f := range files {
+		owned[f] = true
+	}
+	return owned, nil
+}
+
+func saveManifest(workspaceRoot string, m *manifest) error {
+	path, err := manifestPath(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, b, 0644); err != nil {
+		return err
+	}
+	path, err = ownedPath(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	files := make([]string, 0, len(m.owned))
+	for f := range m.owned {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	b, err = json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b, 0644)
+}
+
+// protoHash returns the hash of the given proto's current contents, or "" if
+// it can't be read.
+func (r *result) protoHash(protoFile string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok := r.protoHashes[protoFile]; ok {
+		return h
+	}
+	h := ""
+	if b, err := os.ReadFile(fsPath(protoFile)); err == nil {
+		h = contentHash(b)
+	}
+	if r.protoHashes == nil {
+		r.protoHashes = map[string]string{}
+	}
+	r.protoHashes[protoFile] = h
+	return h
+}
+
+// manifestEntry returns the manifest entry for dest, if any.
+func (r *result) manifestEntry(dest string) *manifestEntry {
+	if r.manifest == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.manifest.Files[dest]
+}
+
+// owns returns whether pbsync has written dest before.
+func (r *result) owns(dest string) bool {
+	if r.manifest == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.manifest.owned[dest]
+}
+
+// recordSynced records that dest is in sync with the contents b generated
+// from protoFile.
+func (r *result) recordSynced(protoFile, dest string, b []byte) {
+	if r.manifest == nil {
+		return
+	}
+	r.mu.Lock()
+	if r.manifest.owned == nil {
+		r.manifest.owned = map[string]bool{}
+	}
+	r.manifest.owned[dest] = true
+	r.mu.Unlock()
+	if filepath.Ext(protoFile) != ".proto" {
+		return
+	}
+	entry := &manifestEntry{
+		Proto:     protoFile,
+		ProtoHash: r.protoHash(protoFile),
+		Hash:      r.config.comparisonHash(b),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifest.Files[dest] = entry
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Policies for the line endings of synced files.
+const (
+	// newlinesPreserve copies generated files as they are.
+	newlinesPreserve = "preserve"
+	// newlinesLF converts CRLF line endings to LF.
+	newlinesLF = "lf"
+	// newlinesMatch uses the line endings of the existing destination.
+	newlinesMatch = "match"
+	// newlinesGitattributes uses the eol attribute of the destination in
+	// .gitattributes.
+	newlinesGitattributes = "gitattributes"
+)
+
+// newlinePolicy returns how the line endings of synced files are handled.
+func (c *config) newlinePolicy() string {
+	if c == nil || c.Newlines == "" {
+		return newlinesPreserve
+	}
+	return c.Newlines
+}
+
+func validateNewlinePolicy(p string) error {
+	switch p {
+	case "", newlinesPreserve, newlinesLF, newlinesMatch, newlinesGitattributes:
+		return nil
+	}
+	return fmt.Errorf("invalid newlines policy %q (must be %q, %q, %q or %q)", p, newlinesPreserve, newlinesLF, newlinesMatch, newlinesGitattributes)
+}
+
+// normalizeNewlines returns the generated contents b of dest with the line
+// endings dest should have, so that destinations checked out with different
+// line endings don't perpetually differ from the generated files.
+func (r *result) normalizeNewlines(dest string, b, destContents []byte, destExists bool) []byte {
+	switch r.config.newlinePolicy() {
+	case newlinesLF:
+		return toLF(b)
+	case newlinesMatch:
+		if destExists && bytes.IndexByte(destContents, '\n') >= 0 {
+			if usesCRLF(destContents) {
+				return toCRLF(b)
+			}
+			return toLF(b)
+		}
+	case newlinesGitattributes:
+		r.mu.Lock()
+		eol := r.eol[dest]
+		r.mu.Unlock()
+		switch eol {
+		case "crlf":
+			return toCRLF(b)
+		case "lf":
+			return toLF(b)
+		}
+	}
+	return b
+}
+
+// usesCRLF returns whether the first line of b ends with CRLF.
+func usesCRLF(b []byte) bool {
+	i := bytes.IndexByte(b, '\n')
+	return i > 0 && b[i-1] == '\r'
+}
+
+func toLF(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+}
+
+func toCRLF(b []byte) []byte {
+	return bytes.ReplaceAll(toLF(b), []byte("\n"), []byte("\r\n"))
+}
+
+// loadEOLAttributes looks up the eol attributes of the action destinations
+// with a single `git check-attr` call.
+func loadEOLAttributes(workspaceRoot string, actions []*syncAction, result *result) error {
+	var input bytes.Buffer
+	rels := map[string]string{}
+	for _, a := range actions {
+		rel, err := filepath.Rel(workspaceRoot, a.dest)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		rels[rel] = a.dest
+		input.WriteString(rel)
+		input.WriteByte(0)
+	}
+	if len(rels) == 0 {
+		return nil
+	}
+	cmd := exec.Command("git", "check-attr", "-z", "--stdin", "eol")
+	cmd.Dir = workspaceRoot
+	cmd.Stdin = &input
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git check-attr failed: %s", stderr.String())
+	}
+	// The output is a sequence of <path> NUL <attribute> NUL <value> NUL.
+	fields := strings.Split(string(out), "\x00")
+	eol := map[string]string{}
+	for i := 0; i+2 < len(fields); i += 3 {
+		if dest, ok := rels[fields[i]]; ok {
+			eol[dest] = fields[i+2]
+		}
+	}
+	result.mu.Lock()
+	result.eol = eol
+	result.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	nice       = flag.Bool("nice", false, "Run at a lower CPU and IO priority and limit disk throughput (see --nice-io-rate), so that a large sync doesn't slow down other work.")
+	niceIORate = flag.Int64("nice-io-rate", 16<<20, "With --nice, the maximum number of bytes per second to read and write.")
+
+	throttleMu sync.Mutex
+	// throttleNext is the time at which the next read or write may start.
+	throttleNext time.Time
+)
+
+// setNice lowers the process priority if --nice is set.
+func setNice() error {
+	if !*nice {
+		return nil
+	}
+	if *niceIORate <= 0 {
+		return fmt.Errorf("--nice-io-rate must be positive")
+	}
+	if err := lowerPriority(); err != nil {
+		return fmt.Errorf("failed to lower process priority: %s", err)
+	}
+	return nil
+}
+
+// throttle blocks as needed to keep disk IO under --nice-io-rate, given that
+// n bytes are about to be read or written.
+func throttle(n int) {
+	if !*nice || n == 0 {
+		return
+	}
+	throttleMu.Lock()
+	now := time.Now()
+	if throttleNext.Before(now) {
+		throttleNext = now
+	}
+	wait := throttleNext.Sub(now)
+	throttleNext = throttleNext.Add(time.Duration(int64(n) * int64(time.Second) / *niceIORate))
+	throttleMu.Unlock()
+	time.Sleep(wait)
+}
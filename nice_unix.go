@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const niceness = 10
+
+// lowerPriority lowers the CPU priority of the process.
+func lowerPriority() error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, niceness)
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// lowerPriority puts the process in background mode, which lowers its CPU,
+// IO and memory priority.
+func lowerPriority() error {
+	return windows.SetPriorityClass(windows.CurrentProcess(), windows.PROCESS_MODE_BACKGROUND_BEGIN)
+}
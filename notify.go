@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+var (
+	notify = flag.Bool("notify", false, "Show a desktop notification (macOS and Linux) when files are updated or the sync fails. Useful when running under `bb --watch`.")
+)
+
+// notifyResult shows a desktop notification about the outcome of a sync, if
+// --notify is set and there is something to report.
+func notifyResult(updated int64, failed, workspaces int) {
+	if !*notify {
+		return
+	}
+	var msg string
+	switch {
+	case failed > 0:
+		msg = fmt.Sprintf("Failed to sync %d of %d workspace(s)", failed, workspaces)
+	case updated > 0:
+		msg = fmt.Sprintf("Updated %d generated file(s)", updated)
+	default:
+		return
+	}
+	if err := showNotification("pbsync", msg); err != nil {
+		debugf("failed to show notification: %s", err)
+	}
+}
+
+func showNotification(title, msg string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(msg), strconv.Quote(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux":
+		cmd = exec.Command("notify-send", "--app-name=pbsync", title, msg)
+	default:
+		return fmt.Errorf("notifications are not supported on %s", runtime.GOOS)
+	}
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, b)
+	}
+	return nil
+}
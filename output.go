@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// outputMu serializes writes to stderr, so that messages printed by
+// concurrent goroutines (one per workspace, proto and file) never interleave
+// mid-line, nor within an outputBlock.
+var outputMu sync.Mutex
+
+// writeOutput writes b to stderr atomically.
+func writeOutput(b []byte) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	os.Stderr.Write(b)
+}
+
+// outputBlock buffers a multi-line message, e.g. a warning followed by the
+// list of things it is about, to print it at once with flush.
+type outputBlock struct {
+	buf bytes.Buffer
+}
+
+func (b *outputBlock) printf(msg string, args ...any) {
+	fmt.Fprintf(&b.buf, msg, args...)
+	debugf(strings.TrimSuffix(msg, "\n"), args...)
+}
+
+func (b *outputBlock) warnf(msg string, args ...any) {
+	b.printf("pbsync: warning: "+msg+"\n", args...)
+}
+
+// write adds the output of a command to the block, indented.
+func (b *outputBlock) write(out []byte) {
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" {
+			b.printf("  %s\n", line)
+		}
+	}
+}
+
+// flush prints the buffered message.
+func (b *outputBlock) flush() {
+	writeOutput(b.buf.Bytes())
+	b.buf.Reset()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// outputGroupQuery is the result of querying the output groups of a rule,
+// shared by the protos of the rule.
+type outputGroupQuery struct {
+	once sync.Once
+	out  string
+	err  error
+}
+
+// outputGroupSrcAndDest resolves the generated files of rule from the given
+// output groups with `bazel cquery`, for rules whose interesting outputs
+// aren't in the default locations (such as .d.ts declarations only exposed
+// through a non-default output group). Each rule is queried once per sync.
+func outputGroupSrcAndDest(workspaceRoot, bazelBin, protoFile string, rule *languageProtoRule, groups []string, result *result) ([]srcAndDest, error) {
+	label := rule.label()
+	v, _ := result.outputGroupQueries.LoadOrStore(label, &outputGroupQuery{})
+	q := v.(*outputGroupQuery)
+	q.once.Do(func() {
+		q.out, q.err = queryOutputGroups(workspaceRoot, label, groups)
+	})
+	if q.err != nil {
+		return nil, q.err
+	}
+	out := q.out
+
+	var res []srcAndDest
+	for _, path := range strings.Split(out, "\n") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		// Paths are relative to the execroot, e.g.
+		// bazel-out/k8-fastbuild/bin/proto/foo.d.ts.
+		parts := strings.SplitN(path, "/", 4)
+		if len(parts) != 4 || parts[0] != "bazel-out" || parts[2] != "bin" {
+			debugf("%s: ignoring output %s outside of bazel-bin", label, path)
+			continue
+		}
+		binRelpath := filepath.FromSlash(parts[3])
+		dest := filepath.Join(workspaceRoot, binRelpath)
+		if rule.kind == goProtoLibrary {
+			wsRelpath, err := goDestDir(workspaceRoot, rule.importPath)
+			if err != nil {
+				return nil, err
+			}
+			dest = filepath.Join(workspaceRoot, wsRelpath, filepath.Base(binRelpath))
+		}
+		res = append(res, srcAndDest{src: filepath.Join(bazelBin, binRelpath), dest: dest})
+	}
+	return res, nil
+}
+
+// queryOutputGroups returns the execroot-relative paths of the files in the
+// given output groups of the rule label, one per line.
+func queryOutputGroups(workspaceRoot, label string, groups []string) (string, error) {
+	var quoted []string
+	for _, g := range groups {
+		quoted = append(quoted, strconv.Quote(g))
+	}
+	expr := fmt.Sprintf(`"\n".join([f.path for g in [%s] for f in getattr(providers(target)["OutputGroupInfo"], g, depset()).to_list()])`, strings.Join(quoted, ", "))
+	args := append([]string{"cquery", label, "--output=starlark", "--starlark:expr=" + expr}, bazelConfigArgs()...)
+	cmd := exec.Command("bazel", args...)
+	cmd.Dir = workspaceRoot
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to query output groups of %s: %s\n%s", label, err, stderr.String())
+	}
+	return string(out), nil
+}
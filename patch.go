@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	outputPatch = flag.String("output-patch", "", "If set, write a `git apply`-able patch of the pending changes to this file instead of updating the workspace.")
+)
+
+// pendingWrite is a change to a destination file that is written to the
+// --output-patch file instead of the workspace.
+type pendingWrite struct {
+	dest     string
+	contents []byte
+}
+
+// patchMode returns whether changes are written to a patch file.
+func patchMode() bool {
+	return *outputPatch != ""
+}
+
+func validateOutputPatch(command string, dirs []string) error {
+	if !patchMode() {
+		return nil
+	}
+	if command != "" {
+		return fmt.Errorf("--output-patch cannot be used with pbsync %s", command)
+	}
+	if len(dirs) > 1 {
+		return fmt.Errorf("--output-patch only supports a single workspace")
+	}
+	return nil
+}
+
+func (r *result) addPending(dest string, contents []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, pendingWrite{dest: dest, contents: contents})
+}
+
+// writePatch writes a patch of the result's pending writes, with paths
+// relative to the workspace root, to path.
+func writePatch(path, workspaceRoot string, result *result) error {
+	tmp, err := os.MkdirTemp("", "pbsync-patch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	pending := append([]pendingWrite{}, result.pending...)
+	sort.Slice(pending, func(i, j int) bool { return pending[i].dest < pending[j].dest })
+	var patch bytes.Buffer
+	for _, p := range pending {
+		rel, err := filepath.Rel(workspaceRoot, p.dest)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// Diff copies of the old and new contents laid out as a/<rel> and
+		// b/<rel>, so that the patch applies with `git apply` (-p1).
+		oldPath := "/dev/null"
+		old, err := os.ReadFile(fsPath(p.dest))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			oldPath = "a/" + rel
+			if err := writeTempFile(filepath.Join(tmp, oldPath), old); err != nil {
+				return err
+			}
+		}
+		newPath := "b/" + rel
+		if err := writeTempFile(filepath.Join(tmp, newPath), p.contents); err != nil {
+			return err
+		}
+		cmd := exec.Command("git", "diff", "--no-index", "--no-prefix", "--binary", "--", oldPath, newPath)
+		cmd.Dir = tmp
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		// git diff exits with 1 if the files differ.
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("git diff failed: %s\n%s", err, stderr.String())
+		}
+		patch.Write(out)
+	}
+	return os.WriteFile(fsPath(path), patch.Bytes(), 0644)
+}
+
+func writeTempFile(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// syncAction is a planned copy of a generated file into the workspace.
+type syncAction struct {
+	// protoFile is the proto that src is generated from, or the rule label
+	// for rules without a workspace proto and outputs that can't be
+	// attributed to one of the rule's protos.
+	protoFile string
+	// rule is the label of the rule generating src.
+	rule string
+	// kind is the rule's kind, e.g. "go_proto_library".
+	kind string
+	// ruleProtos are the protos of the rule, for outputs attributed to the
+	// rule as a whole (see protoFile).
+	ruleProtos []string
+
+	src, dest string
+}
+
+// planProto returns the sync actions for the outputs of the language rules
+// generating code for protoFile.
+func planProto(workspaceRoot, protoFile string, buildFile *parsedBuildFile, result *result) ([]*syncAction, error) {
+	d, err := protoDirectives(protoFile, buildFile.directives)
+	if err != nil {
+		return nil, err
+	}
+	if d.skip {
+		debugf("%s: skipped by pbsync directive", protoFile)
+		return nil, nil
+	}
+	result.addUnsupported(buildFile.unsupportedRules)
+	for _, label := range buildFile.externalProtoRefs {
+		result.addSkipped(skipExternalProtoRef, label)
+	}
+	rules, ok := buildFile.getLangProtoRulesForProto(workspaceRoot, protoFile)
+	if !ok {
+		debugf("%s: no proto rule found", protoFile)
+		if onlyRulesRe != nil {
+			// None of its rules could be selected anyway.
+			return nil, nil
+		}
+		result.addSkippedProto(workspaceRoot, buildFile.skipReason(workspaceRoot, protoFile), protoFile)
+		result.addMissing(protoFile)
+		return nil, nil
+	}
+
+	bazelBin, err := getBazelBinDir(workspaceRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine bazel bin dir: %s", err)
+	}
+
+	var actions []*syncAction
+	for _, rule := range rules {
+		label := rule.label()
+		if !ruleSelected(label) {
+			debugf("%s: skipping %s, which doesn't match --only-rules", protoFile, label)
+			continue
+		}
+		var srcAndDestPaths []srcAndDest
+		if groups := result.config.outputGroups(rule.kind); len(groups) > 0 {
+			srcAndDestPaths, err = outputGroupSrcAndDest(workspaceRoot, bazelBin, protoFile, &rule, groups, result)
+		} else {
+			srcAndDestPaths, err = rule.getSrcAndDest(workspaceRoot, bazelBin, protoFile)
+			if suffixes := result.config.auxiliarySuffixes(rule.kind); err == nil && len(suffixes) > 0 {
+				srcAndDestPaths = append(srcAndDestPaths, auxiliaryOutputs(protoFile, srcAndDestPaths, suffixes)...)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(srcAndDestPaths) == 0 {
+			result.addSkippedProto(workspaceRoot, skipNotGenerated, protoFile)
+			result.addMissing(protoFile)
+		}
+		if rule.kind == goProtoLibrary {
+			checkGoPackage(workspaceRoot, protoFile, &rule, srcAndDestPaths)
+		}
+		for _, srcAndDest := range srcAndDestPaths {
+			dest := d.applyDest(workspaceRoot, &rule, srcAndDest.dest)
+			if rule.dest == "" && d.dest == "" {
+				// Destinations set by directives aren't moved.
+				dest = result.config.applyDestRoot(workspaceRoot, dest)
+			}
+			a := &syncAction{
+				protoFile: protoFile,
+				rule:      label,
+				kind:      rule.kind,
+				src:       srcAndDest.src,
+				dest:      dest,
+			}
+			if srcAndDest.ruleOutput {
+				a.protoFile = label
+				a.ruleProtos = buildFile.ruleProtos(workspaceRoot, rule.protoRuleName)
+			}
+			actions = append(actions, a)
+		}
+	}
+	return actions, nil
+}
+
+// ruleProtos returns the paths of the protos in the srcs of the
+// proto_library protoRule.
+func (b *parsedBuildFile) ruleProtos(workspaceRoot, protoRule string) []string {
+	var res []string
+	for key, r := range b.protoFileToRule {
+		if r == protoRule {
+			res = append(res, filepath.Join(workspaceRoot, filepath.FromSlash(key)))
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// skipReason returns why protoFile has no language rules to sync.
+func (b *parsedBuildFile) skipReason(workspaceRoot, protoFile string) string {
+	protoRule, ok := b.protoFileToRule[protoKey(workspaceRoot, protoFile)]
+	if !ok {
+		return skipNoProtoRule
+	}
+	if len(b.unsupportedKinds[protoRule]) > 0 {
+		return skipUnsupportedKind
+	}
+	return skipNoLangRule
+}
+
+// checkCollisions returns an error if two actions would write different
+// generated files to the same destination, which would otherwise make the
+// result depend on which was applied last. Duplicate actions are removed.
+func checkCollisions(actions []*syncAction) ([]*syncAction, error) {
+	sort.SliceStable(actions, func(i, j int) bool {
+		if actions[i].dest != actions[j].dest {
+			return actions[i].dest < actions[j].dest
+		}
+		return actions[i].rule < actions[j].rule
+	})
+	var res []*syncAction
+	for _, a := range actions {
+		if len(res) > 0 {
+			prev := res[len(res)-1]
+			if prev.dest == a.dest {
+				if prev.src == a.src {
+					continue
+				}
+				return nil, fmt.Errorf("destination %s is generated by both %s (%s) and %s (%s)", a.dest, prev.rule, prev.src, a.rule, a.src)
+			}
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+// applyActions syncs the planned files into the workspace.
+func applyActions(ctx context.Context, workspaceRoot string, actions []*syncAction, result *result) error {
+	eg := errgroup.Group{}
+	for _, a := range actions {
+		a := a
+		eg.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if isStale(a.protoFile, a.src) {
+				result.addStale(a.rule)
+				if *strictFreshness {
+					return nil
+				}
+			}
+			err := syncFile(a.protoFile, a.src, a.dest, result)
+			if err == errNotGenerated {
+				// Only workspace protos can be generated as a fallback.
+				if strings.HasSuffix(a.protoFile, ".proto") {
+					result.addSkippedProto(workspaceRoot, skipNotGenerated, a.protoFile)
+					result.addMissing(a.protoFile)
+				} else {
+					result.addSkipped(skipNotGenerated, a.rule)
+				}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			result.countKind(a.kind)
+			return nil
+		})
+	}
+	return eg.Wait()
+}
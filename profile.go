@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	profile = flag.String("profile", "", "Name of a profile in .pbsync.yaml whose flag values to use (e.g. ci or laptop). Flags given on the command line take precedence.")
+)
+
+const (
+	// profileConfigKey is the profile setting holding config settings
+	// (e.g. dest_roots or output_root) that override those of the config,
+	// rather than a flag.
+	profileConfigKey = "config"
+)
+
+// applyProfile sets the flags of the --profile selected in the config of
+// workspaceRoot, unless they were given on the command line, and returns
+// the names of the flags it set.
+func applyProfile(workspaceRoot string) (map[string]bool, error) {
+	if *profile == "" {
+		return nil, nil
+	}
+	cfg, err := loadConfig(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := cfg.Profiles[*profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %q is not defined in %s", *profile, filepath.Join(workspaceRoot, configFileName))
+	}
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	var names []string
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	set := map[string]bool{}
+	for _, name := range names {
+		if name == profileConfigKey {
+			// Applied by loadConfig.
+			continue
+		}
+		if name == "profile" || flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("profile %q: unknown flag %q", *profile, name)
+		}
+		if explicit[name] {
+			debugf("profile %s: --%s given on the command line takes precedence", *profile, name)
+			continue
+		}
+		value := profileValue(p[name])
+		if err := flag.Set(name, value); err != nil {
+			return nil, fmt.Errorf("profile %q: invalid value %q for %s: %s", *profile, value, name, err)
+		}
+		debugf("profile %s: --%s=%s", *profile, name, value)
+		set[name] = true
+	}
+	return set, nil
+}
+
+// applyProfileConfig overrides the settings of cfg with those of the
+// --profile's config setting, if any. As with the local config, maps are
+// merged and other values replaced.
+func applyProfileConfig(cfg *config) error {
+	if *profile == "" {
+		return nil
+	}
+	overrides, ok := cfg.Profiles[*profile][profileConfigKey]
+	if !ok {
+		return nil
+	}
+	if _, ok := overrides.(map[string]any); !ok {
+		return fmt.Errorf("profile %q: %s must be a map of config settings", *profile, profileConfigKey)
+	}
+	b, err := yaml.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return fmt.Errorf("profile %q: invalid %s: %s", *profile, profileConfigKey, err)
+	}
+	return nil
+}
+
+// profileValue returns the flag value for a profile setting. Lists are
+// joined with commas, as in --verify=go,ts.
+func profileValue(v any) string {
+	if list, ok := v.([]any); ok {
+		var values []string
+		for _, e := range list {
+			values = append(values, fmt.Sprint(e))
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v)
+}
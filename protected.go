@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// protectedBy returns the config's protected pattern matching dest, or "" if
+// pbsync may write it.
+func (r *result) protectedBy(dest string) string {
+	if r.config == nil || len(r.config.Protected) == 0 || r.destRoot() == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(r.destRoot(), dest)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range r.config.Protected {
+		if matchPath(pattern, rel) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// matchPath returns whether the slash-separated path matches pattern, whose
+// "/"-separated segments are matched with path.Match, except for "**",
+// which matches any number of segments.
+func matchPath(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func validateProtectedPatterns(patterns []string) error {
+	for _, p := range patterns {
+		for _, seg := range strings.Split(p, "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("invalid protected pattern %q: %s", p, err)
+			}
+		}
+	}
+	return nil
+}
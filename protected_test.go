@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"api/foo.pb.go", "api/foo.pb.go", true},
+		{"api/foo.pb.go", "api/bar.pb.go", false},
+		{"api/*.pb.go", "api/foo.pb.go", true},
+		{"api/*.pb.go", "api/v1/foo.pb.go", false},
+		{"*.pb.go", "api/foo.pb.go", false},
+		{"api/**", "api/foo.pb.go", true},
+		{"api/**", "api/v1/foo.pb.go", true},
+		{"api/**", "api", true},
+		{"api/**", "apis/foo.pb.go", false},
+		{"**/*.pb.go", "foo.pb.go", true},
+		{"**/*.pb.go", "api/v1/foo.pb.go", true},
+		{"**/*.pb.go", "api/v1/foo.d.ts", false},
+		{"api/**/legacy/*.go", "api/legacy/foo.go", true},
+		{"api/**/legacy/*.go", "api/v1/v2/legacy/foo.go", true},
+		{"api/**/legacy/*.go", "api/v1/legacy/sub/foo.go", false},
+		{"**", "anything/at/all", true},
+		{"api/[ab].go", "api/a.go", true},
+		{"api/[ab].go", "api/c.go", false},
+	} {
+		if got := matchPath(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestValidateProtectedPatterns(t *testing.T) {
+	if err := validateProtectedPatterns([]string{"api/**", "**/*.pb.go"}); err != nil {
+		t.Errorf("validateProtectedPatterns() failed: %s", err)
+	}
+	if err := validateProtectedPatterns([]string{"api/[a.go"}); err == nil {
+		t.Errorf("validateProtectedPatterns() accepted a malformed pattern")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+var (
+	protoQuery = flag.String("query", "", "Bazel query expression (e.g. 'kind(proto_library, //services/...)') selecting the proto_library rules whose protos to sync, instead of discovering protos with `git ls-files`.")
+)
+
+func validateQuery() error {
+	if *protoQuery == "" {
+		return nil
+	}
+	if *protoListFile != "" || *changedOnly {
+		return fmt.Errorf("--query can't be combined with --protos or --changed")
+	}
+	return nil
+}
+
+// queryProtos returns the workspace-relative paths of the protos in the
+// srcs of the proto_library rules matched by --query.
+func queryProtos(workspaceRoot string) ([]string, error) {
+	expr := fmt.Sprintf("kind('source file', labels(srcs, kind(proto_library, %s)))", *protoQuery)
+	args := append([]string{"query", expr, "--output=label"}, bazelConfigArgs()...)
+	cmd := exec.Command("bazel", args...)
+	cmd.Dir = workspaceRoot
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("bazel query failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var protos []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		l, err := parseLabel(line, "")
+		if err != nil {
+			return nil, fmt.Errorf("unexpected bazel query output %q: %s", line, err)
+		}
+		if l.repo != "" || !strings.HasSuffix(l.name, ".proto") {
+			// Protos from other repositories aren't synced.
+			continue
+		}
+		protos = append(protos, path.Join(l.pkg, l.name))
+	}
+	debugf("%s: query matched %d protos", workspaceRoot, len(protos))
+	return protos, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	readTimeout = flag.Duration("read-timeout", 30*time.Second, "How long to wait for reads (and stats) of generated files when bazel-bin is on a FUSE filesystem (e.g. a remote output service), which may block while fetching them. 0 means no timeout.")
+
+	// remoteBazelBins maps the bazel-bin directories on FUSE filesystems to
+	// their *remoteBazelBin.
+	remoteBazelBins sync.Map
+)
+
+const remoteFSHint = "with Build without the Bytes, build with --remote_download_outputs=all, or configure the output service to fetch outputs when they are read"
+
+// remoteBazelBin is a bazel-bin directory on a FUSE filesystem.
+type remoteBazelBin struct {
+	dir    string
+	fsType string
+	// hung is set once an operation in dir timed out. Further operations
+	// fail right away, so that at most one goroutine per concurrent
+	// operation is left blocked on the unresponsive filesystem.
+	hung int32
+}
+
+// bazelBinFilesystem returns the type of the FUSE filesystem bazel-bin is
+// on, or "" if it isn't on one. Operations on files in a bazel-bin on a
+// FUSE filesystem are then subject to --read-timeout.
+func bazelBinFilesystem(workspaceRoot string) string {
+	bazelBin, err := getBazelBinDir(workspaceRoot)
+	if err != nil {
+		return ""
+	}
+	fsType, err := fuseFilesystem(bazelBin)
+	if err != nil {
+		debugf("could not determine the filesystem of %s: %s", bazelBin, err)
+		return ""
+	}
+	if fsType != "" {
+		debugf("%s is on a %s filesystem", bazelBin, fsType)
+		remoteBazelBins.LoadOrStore(bazelBin, &remoteBazelBin{dir: bazelBin, fsType: fsType})
+	}
+	return fsType
+}
+
+// remoteBazelBinOf returns the remote bazel-bin containing path, if any.
+func remoteBazelBinOf(path string) *remoteBazelBin {
+	var res *remoteBazelBin
+	remoteBazelBins.Range(func(_, v any) bool {
+		if rb := v.(*remoteBazelBin); isUnder(path, rb.dir) {
+			res = rb
+			return false
+		}
+		return true
+	})
+	return res
+}
+
+// withReadTimeout runs f, an operation on path, which is a generated file
+// or directory. If path is in a bazel-bin on a FUSE filesystem, it gives up
+// after --read-timeout, and errors suggest how to make the outputs
+// available locally. The goroutine running f can't be interrupted, so on a
+// timeout it stays blocked until the filesystem responds.
+func withReadTimeout[T any](path string, f func() (T, error)) (T, error) {
+	rb := remoteBazelBinOf(path)
+	if rb == nil {
+		return f()
+	}
+	var zero T
+	if atomic.LoadInt32(&rb.hung) != 0 {
+		return zero, fmt.Errorf("not reading %s after an earlier read timed out (bazel-bin is on a %s filesystem; %s)", path, rb.fsType, remoteFSHint)
+	}
+	ch := make(chan Result[T], 1)
+	go func() {
+		v, err := f()
+		ch <- Result[T]{Val: v, Err: err}
+	}()
+	var timeout <-chan time.Time
+	if *readTimeout > 0 {
+		timeout = time.After(*readTimeout)
+	}
+	select {
+	case r := <-ch:
+		if r.Err != nil && !os.IsNotExist(r.Err) {
+			return r.Val, fmt.Errorf("%s (bazel-bin is on a %s filesystem; %s)", r.Err, rb.fsType, remoteFSHint)
+		}
+		return r.Val, r.Err
+	case <-timeout:
+		atomic.StoreInt32(&rb.hung, 1)
+		return zero, fmt.Errorf("timed out after %s reading %s (bazel-bin is on a %s filesystem; %s)", *readTimeout, path, rb.fsType, remoteFSHint)
+	}
+}
+
+// readGenerated reads the generated file src, subject to --read-timeout.
+func readGenerated(src string) ([]byte, error) {
+	return withReadTimeout(src, func() ([]byte, error) {
+		return os.ReadFile(fsPath(src))
+	})
+}
+
+// statGenerated stats the generated file src, subject to --read-timeout.
+func statGenerated(src string) (os.FileInfo, error) {
+	return withReadTimeout(src, func() (os.FileInfo, error) {
+		return os.Stat(fsPath(src))
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// fuseFilesystem returns the type of the FUSE filesystem (e.g. "macfuse")
+// path is on, or "".
+func fuseFilesystem(path string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", err
+	}
+	fsType := unix.ByteSliceToString(st.Fstypename[:])
+	if strings.Contains(fsType, "fuse") {
+		return fsType, nil
+	}
+	return "", nil
+}
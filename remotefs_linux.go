@@ -0,0 +1,19 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const fuseSuperMagic = 0x65735546
+
+// fuseFilesystem returns "fuse" if path is on a FUSE filesystem, or "".
+func fuseFilesystem(path string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", err
+	}
+	if st.Type == fuseSuperMagic {
+		return "fuse", nil
+	}
+	return "", nil
+}
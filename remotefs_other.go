@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// fuseFilesystem returns "": FUSE filesystems aren't detected on this
+// platform.
+func fuseFilesystem(path string) (string, error) {
+	return "", nil
+}
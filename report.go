@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	reportFile = flag.String("report", "", "In check mode, also write the findings to this file. The format is chosen by the extension: .sarif for SARIF, .xml for JUnit XML.")
+)
+
+// reportWriters maps report file extensions to the functions writing them.
+var reportWriters = map[string]func(workspaces []*workspaceResult) ([]byte, error){
+	".sarif": sarifReport,
+	".xml":   junitReport,
+}
+
+func validateReport() error {
+	if *reportFile == "" {
+		return nil
+	}
+	if !checkMode {
+		return fmt.Errorf("--report is only supported by pbsync check")
+	}
+	if reportWriter(*reportFile) == nil {
+		return fmt.Errorf("--report %q: unsupported report format", *reportFile)
+	}
+	return nil
+}
+
+func reportWriter(path string) func([]*workspaceResult) ([]byte, error) {
+	return reportWriters[strings.ToLower(filepath.Ext(path))]
+}
+
+// writeReport writes the check mode findings of the workspaces to
+// --report, if set.
+func writeReport(workspaces []*workspaceResult) error {
+	if *reportFile == "" {
+		return nil
+	}
+	b, err := reportWriter(*reportFile)(workspaces)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fsPath(*reportFile), b, 0644)
+}
+
+// reportPath returns the path to use for a finding's file in reports:
+// relative to the workspace root, with forward slashes.
+func reportPath(workspaceRoot, path string) string {
+	if rel, err := filepath.Rel(workspaceRoot, path); err == nil {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+// findingDescriptions describe the kinds of findings for reports.
+var findingDescriptions = map[string]string{
+	findingOutOfDate:    "Generated file is out of date",
+	findingStaleOutput:  "Generated file was built from an older version of its proto",
+	findingNotGenerated: "Proto has no generated files",
+}
+
+// The subset of SARIF 2.1.0 that pbsync reports.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful bool `json:"executionSuccessful"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+func sarifReport(workspaces []*workspaceResult) ([]byte, error) {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "pbsync",
+			InformationURI: "https://github.com/buildbuddy-io/pbsync",
+		}},
+		Invocations: []sarifInvocation{{ExecutionSuccessful: true}},
+		Results:     []sarifResult{},
+	}
+	for _, kind := range []string{findingOutOfDate, findingStaleOutput, findingNotGenerated} {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               kind,
+			ShortDescription: sarifMessage{Text: findingDescriptions[kind]},
+		})
+	}
+	for _, ws := range workspaces {
+		if ws.err != nil {
+			run.Invocations[0].ExecutionSuccessful = false
+		}
+		if ws.result == nil {
+			continue
+		}
+		for _, f := range ws.result.findings {
+			run.Results = append(run.Results, sarifResult{
+				RuleID:  f.kind,
+				Level:   "error",
+				Message: sarifMessage{Text: f.message},
+				Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: reportPath(ws.dir, f.path)},
+				}}},
+			})
+		}
+	}
+	log := &sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+	b, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// The subset of the JUnit XML format that pbsync reports.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+}
+
+// junitReport reports a test suite per workspace, with a test case per
+// destination file (named after the file, with its proto as the class)
+// that fails if the file is not up to date.
+func junitReport(workspaces []*workspaceResult) ([]byte, error) {
+	report := &junitTestSuites{}
+	for _, ws := range workspaces {
+		suite := junitTestSuite{Name: ws.dir}
+		if ws.err != nil {
+			suite.Errors++
+			suite.Cases = append(suite.Cases, junitTestCase{
+				ClassName: "pbsync",
+				Name:      "sync",
+				Error:     &junitFailure{Message: ws.err.Error()},
+			})
+		}
+		if ws.result != nil {
+			cases := map[string]*junitTestCase{}
+			for _, a := range ws.result.actions {
+				cases[a.dest] = &junitTestCase{
+					ClassName: reportPath(ws.dir, a.protoFile),
+					Name:      reportPath(ws.dir, a.dest),
+				}
+			}
+			for _, f := range ws.result.findings {
+				c, ok := cases[f.path]
+				if !ok {
+					c = &junitTestCase{
+						ClassName: reportPath(ws.dir, f.proto),
+						Name:      reportPath(ws.dir, f.path),
+					}
+					cases[f.path] = c
+				}
+				if c.Failure == nil {
+					suite.Failures++
+				}
+				c.Failure = &junitFailure{Message: f.message, Type: f.kind}
+			}
+			var paths []string
+			for path := range cases {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			for _, path := range paths {
+				suite.Cases = append(suite.Cases, *cases[path])
+			}
+		}
+		suite.Tests = len(suite.Cases)
+		report.Suites = append(report.Suites, suite)
+	}
+	b, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+var (
+	cacheClear = flag.Bool("cache-clear", false, "Remove the cached bazel info, manifest of past syncs and churn history of the synced workspaces before syncing.")
+)
+
+// clearCache removes the cache entries of the workspaces, if --cache-clear
+// is set. Lock files and debounce queues, which other pbsync processes may
+// be using, are kept, and each workspace is locked while its entries are
+// removed.
+func clearCache(workspaceRoots []string) error {
+	if !*cacheClear {
+		return nil
+	}
+	for _, root := range workspaceRoots {
+		if err := clearWorkspaceCache(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clearWorkspaceCache(workspaceRoot string) error {
+	unlock, err := lockWorkspace(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	manifest, err := manifestPath(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	history, err := historyPath(workspaceRoot)
+	if err != nil {
+		return err
+	}
+	bazelBin, err := cachePath(cacheKey(bazelBinKey, workspaceRoot))
+	if err != nil {
+		return err
+	}
+	var size int64
+	files := 0
+	for _, path := range []string{manifest, history, bazelBin} {
+		info, err := os.Stat(fsPath(path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(fsPath(path)); err != nil {
+			return err
+		}
+		size += info.Size()
+		files++
+	}
+	if !*quiet {
+		printf("pbsync: %s: cleared cache (%d files, %d bytes)\n", workspaceRoot, files, size)
+	}
+	return nil
+}
+
+// dirSize returns the total size and number of the files in dir.
+func dirSize(dir string) (size int64, files int) {
+	filepath.WalkDir(fsPath(dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+			files++
+		}
+		return nil
+	})
+	return size, files
+}
+
+// resourceUsage is the resources used by a run.
+type resourceUsage struct {
+	// HeapBytes is the size of the live heap at the end of the run.
+	HeapBytes uint64 `json:"heap_bytes"`
+	// AllocatedBytes is the total size of the memory allocated.
+	AllocatedBytes uint64 `json:"allocated_bytes"`
+	// SysBytes is the memory obtained from the OS.
+	SysBytes   uint64 `json:"sys_bytes"`
+	GCCycles   uint32 `json:"gc_cycles"`
+	CacheFiles int    `json:"cache_files"`
+	CacheBytes int64  `json:"cache_bytes"`
+	Workspaces int    `json:"workspaces"`
+	// Files is the number of generated files looked at.
+	Files int `json:"files"`
+}
+
+// measureResources returns the resources used by the run: memory usage,
+// the size of the cache, and the numbers of workspaces and files looked
+// at, to help diagnose slow or memory-hungry runs. They are written to the
+// log and the --result-file.
+func measureResources(workspaces []*workspaceResult) *resourceUsage {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	u := &resourceUsage{
+		HeapBytes:      m.HeapAlloc,
+		AllocatedBytes: m.TotalAlloc,
+		SysBytes:       m.Sys,
+		GCCycles:       m.NumGC,
+		Workspaces:     len(workspaces),
+	}
+	for _, ws := range workspaces {
+		if ws.result != nil {
+			u.Files += len(ws.result.actions)
+		}
+	}
+	if dir, err := cacheDir(); err == nil {
+		u.CacheBytes, u.CacheFiles = dirSize(dir)
+	}
+	debugf("resources: heap: %d bytes, total allocated: %d bytes, memory from OS: %d bytes, GC cycles: %d", u.HeapBytes, u.AllocatedBytes, u.SysBytes, u.GCCycles)
+	debugf("resources: cache: %d files, %d bytes; workspaces: %d; generated files: %d", u.CacheFiles, u.CacheBytes, u.Workspaces, u.Files)
+	return u
+}
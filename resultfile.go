@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	resultFile = flag.String("result-file", "", "Write a JSON summary of the sync (counts, time, errors and resource usage) to this file, replacing it atomically, e.g. for editor status bars to poll.")
+)
+
+// syncResult is the summary written to --result-file.
+type syncResult struct {
+	Time       time.Time              `json:"time"`
+	DurationMs int64                  `json:"duration_ms"`
+	Updated    int64                  `json:"updated"`
+	UpToDate   int64                  `json:"up_to_date"`
+	Skipped    int                    `json:"skipped"`
+	Failed     int                    `json:"failed"`
+	Workspaces []*syncWorkspaceResult `json:"workspaces"`
+	Resources  *resourceUsage         `json:"resources"`
+}
+
+type syncWorkspaceResult struct {
+	Dir      string `json:"dir"`
+	Updated  int64  `json:"updated"`
+	UpToDate int64  `json:"up_to_date"`
+	Error    string `json:"error,omitempty"`
+}
+
+func validateResultFile(command string) error {
+	if *resultFile == "" {
+		return nil
+	}
+	switch command {
+	case "", commitCommand, bsrPullCommand:
+		return nil
+	}
+	return fmt.Errorf("--result-file is not supported by pbsync %s", command)
+}
+
+// writeResultFile writes the summary of the sync of the workspaces to
+// --result-file, if set. The file is replaced atomically, so readers never
+// see a partial result.
+func writeResultFile(start time.Time, workspaces []*workspaceResult, total *result, failed int, usage *resourceUsage) error {
+	if *resultFile == "" {
+		return nil
+	}
+	r := &syncResult{
+		Time:       start,
+		DurationMs: time.Since(start).Milliseconds(),
+		Updated:    total.created,
+		UpToDate:   total.upToDate,
+		Failed:     failed,
+		Workspaces: []*syncWorkspaceResult{},
+		Resources:  usage,
+	}
+	for _, protos := range total.skipped {
+		r.Skipped += len(protos)
+	}
+	for _, ws := range workspaces {
+		w := &syncWorkspaceResult{Dir: ws.dir}
+		if ws.err != nil {
+			w.Error = ws.err.Error()
+		} else {
+			w.Updated = ws.result.created
+			w.UpToDate = ws.result.upToDate
+		}
+		r.Workspaces = append(r.Workspaces, w)
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	return writeFileAtomic(*resultFile, b, 0644)
+}
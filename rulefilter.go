@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+)
+
+var (
+	onlyRules = flag.String("only-rules", "", "If set, only sync the outputs of language rules whose labels (e.g. //proto/api:api_go_proto) fully match this regular expression.")
+
+	onlyRulesRe *regexp.Regexp
+)
+
+func validateOnlyRules() error {
+	if *onlyRules == "" {
+		return nil
+	}
+	if _, err := regexp.Compile(*onlyRules); err != nil {
+		return fmt.Errorf("invalid --only-rules: %s", err)
+	}
+	onlyRulesRe = regexp.MustCompile("^(?:" + *onlyRules + ")$")
+	return nil
+}
+
+// ruleSelected returns whether the outputs of the rule with the given label
+// should be synced.
+func ruleSelected(label string) bool {
+	return onlyRulesRe == nil || onlyRulesRe.MatchString(label)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/build"
+)
+
+// protoGRPCRule describes a rule kind from rules_proto_grpc
+// (https://github.com/rules-proto-grpc/rules_proto_grpc).
+type protoGRPCRule struct {
+	// suffixes are the suffixes of the files generated for each proto,
+	// appended to the proto's base name.
+	suffixes []string
+	// goPackage is whether the rule generates Go code, whose destination
+	// follows the rule's importpath if it has one.
+	goPackage bool
+	// library is whether the kind is a library macro, which generates its
+	// files with an internal <name>_pb compile rule.
+	library bool
+}
+
+var (
+	// protoGRPCRules are the supported rules_proto_grpc rule kinds.
+	protoGRPCRules = map[string]*protoGRPCRule{
+		"go_proto_compile":       {suffixes: []string{".pb.go"}, goPackage: true},
+		"go_grpc_compile":        {suffixes: []string{".pb.go", "_grpc.pb.go"}, goPackage: true},
+		"go_grpc_library":        {suffixes: []string{".pb.go", "_grpc.pb.go"}, goPackage: true, library: true},
+		"python_proto_compile":   {suffixes: []string{"_pb2.py"}},
+		"python_proto_library":   {suffixes: []string{"_pb2.py"}, library: true},
+		"python_grpc_compile":    {suffixes: []string{"_pb2.py", "_pb2_grpc.py"}},
+		"python_grpc_library":    {suffixes: []string{"_pb2.py", "_pb2_grpc.py"}, library: true},
+		"cpp_proto_compile":      {suffixes: []string{".pb.h", ".pb.cc"}},
+		"cpp_proto_library":      {suffixes: []string{".pb.h", ".pb.cc"}, library: true},
+		"cpp_grpc_compile":       {suffixes: []string{".pb.h", ".pb.cc", ".grpc.pb.h", ".grpc.pb.cc"}},
+		"cpp_grpc_library":       {suffixes: []string{".pb.h", ".pb.cc", ".grpc.pb.h", ".grpc.pb.cc"}, library: true},
+		"csharp_proto_compile":   {suffixes: []string{".cs"}},
+		"csharp_grpc_compile":    {suffixes: []string{".cs", "Grpc.cs"}},
+		"ruby_proto_compile":     {suffixes: []string{"_pb.rb"}},
+		"ruby_grpc_compile":      {suffixes: []string{"_pb.rb", "_services_pb.rb"}},
+		"objc_proto_compile":     {suffixes: []string{".pbobjc.h", ".pbobjc.m"}},
+		"php_proto_compile":      {suffixes: []string{".php"}},
+		"swift_proto_compile":    {suffixes: []string{".pb.swift"}},
+		"swift_grpc_compile":     {suffixes: []string{".pb.swift", ".grpc.swift"}},
+		"js_proto_compile":       {suffixes: []string{"_pb.js", "_pb.d.ts"}},
+		"js_grpc_node_compile":   {suffixes: []string{"_pb.js", "_pb.d.ts", "_grpc_pb.js", "_grpc_pb.d.ts"}},
+		"js_grpc_web_compile":    {suffixes: []string{"_pb.js", "_pb.d.ts", "_grpc_web_pb.js", "_grpc_web_pb.d.ts"}},
+		"python_grpclib_compile": {suffixes: []string{"_pb2.py", "_grpc.py"}},
+	}
+)
+
+// parseProtoGRPCRule returns a languageProtoRule for each local proto_library
+// in the protos attribute of a rules_proto_grpc rule.
+func parseProtoGRPCRule(buildFilePath, pkg string, r *build.Rule) ([]languageProtoRule, error) {
+	dest, err := ruleDest(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: rule %q: %s", buildFilePath, r.Name(), err)
+	}
+	var res []languageProtoRule
+	for _, protoRule := range r.AttrStrings("protos") {
+		if !strings.HasPrefix(protoRule, ":") {
+			continue
+		}
+		res = append(res, languageProtoRule{
+			kind:          r.Kind(),
+			name:          r.Name(),
+			protoRuleName: protoRule[1:],
+			importPath:    r.AttrString("importpath"),
+			pkg:           pkg,
+			dest:          dest,
+		})
+	}
+	return res, nil
+}
+
+// protoGRPCSrcAndDest returns the files generated for protoPath by a
+// rules_proto_grpc rule. With the default output mode, they are written to
+// <name>/ in the rule's package (<name>_pb/ for library macros, after their
+// compile rule), mirroring the workspace-relative path of the proto, and
+// are synced next to the proto.
+func (r *languageProtoRule) protoGRPCSrcAndDest(workspaceRoot, bazelBin, protoPath string, kind *protoGRPCRule) ([]srcAndDest, error) {
+	relDir, err := filepath.Rel(workspaceRoot, filepath.Dir(protoPath))
+	if err != nil {
+		return nil, err
+	}
+	destDir := filepath.Join(workspaceRoot, relDir)
+	if kind.goPackage && r.importPath != "" {
+		wsRelpath, err := goDestDir(workspaceRoot, r.importPath)
+		if err != nil {
+			return nil, err
+		}
+		destDir = filepath.Join(workspaceRoot, wsRelpath)
+	}
+	compileName := r.name
+	if kind.library {
+		compileName += "_pb"
+	}
+	outDir := filepath.Join(bazelBin, filepath.FromSlash(r.pkg), compileName, relDir)
+	base := strings.TrimSuffix(filepath.Base(protoPath), ".proto")
+	var res []srcAndDest
+	for _, suffix := range kind.suffixes {
+		src := filepath.Join(outDir, base+suffix)
+		if _, err := statGenerated(src); err != nil {
+			continue
+		}
+		res = append(res, srcAndDest{src: src, dest: filepath.Join(destDir, base+suffix)})
+	}
+	return res, nil
+}
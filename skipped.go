@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Reasons for skipping protos (or, for skipExternalProtoRef, rules), in the
+// order they are summarized in.
+const (
+	skipNoBuildFile      = "no BUILD file in the proto's directory"
+	skipNoProtoRule      = "not in the srcs of a proto_library"
+	skipNoLangRule       = "no language rule for its proto_library"
+	skipUnsupportedKind  = "only unsupported language rule kinds"
+	skipExternalProtoRef = "language rules referencing a proto_library in another package"
+	skipNotGenerated     = "outputs not built"
+)
+
+var skipReasons = []string{
+	skipNoBuildFile,
+	skipNoProtoRule,
+	skipNoLangRule,
+	skipUnsupportedKind,
+	skipExternalProtoRef,
+	skipNotGenerated,
+}
+
+// maxSkippedExamples is the number of skipped protos listed per reason.
+const maxSkippedExamples = 3
+
+func (r *result) addSkipped(reason, item string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.skipped == nil {
+		r.skipped = map[string]map[string]bool{}
+	}
+	if r.skipped[reason] == nil {
+		r.skipped[reason] = map[string]bool{}
+	}
+	r.skipped[reason][item] = true
+}
+
+// addSkippedProto records that protoFile was skipped for the given reason.
+func (r *result) addSkippedProto(workspaceRoot, reason, protoFile string) {
+	r.addSkipped(reason, protoKey(workspaceRoot, protoFile))
+}
+
+// mergeSkipped adds the skipped items of a workspace's result, prefixed with
+// prefix.
+func (r *result) mergeSkipped(prefix string, ws *result) {
+	for reason, items := range ws.skipped {
+		for item := range items {
+			if prefix != "" && !strings.HasPrefix(item, "//") {
+				item = filepath.ToSlash(filepath.Join(prefix, item))
+			}
+			r.addSkipped(reason, item)
+		}
+	}
+}
+
+// printSkipped prints the number of skipped protos per reason, with a few
+// examples each. The full lists go to the log file.
+func printSkipped(skipped map[string]map[string]bool) {
+	if len(skipped) == 0 {
+		return
+	}
+	printf("pbsync: skipped:\n")
+	for _, reason := range skipReasons {
+		var items []string
+		for item := range skipped[reason] {
+			items = append(items, item)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		sort.Strings(items)
+		debugf("skipped (%s): %s", reason, strings.Join(items, ", "))
+		examples := items
+		if len(examples) > maxSkippedExamples {
+			examples = append(examples[:maxSkippedExamples:maxSkippedExamples], "...")
+		}
+		printf("  %s (%d): %s\n", reason, len(items), strings.Join(examples, ", "))
+	}
+}
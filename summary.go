@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultSummary = "pbsync: updated: {{.Updated}}, up to date: {{.UpToDate}}, duration: {{.Duration}}"
+)
+
+var (
+	quiet         = flag.Bool("quiet", false, "Don't print a summary of the sync. Warnings and errors are still printed.")
+	summaryFormat = flag.String("summary", defaultSummary, "Template for the summary line, in Go text/template syntax. Fields: .Updated, .UpToDate, .Duration.")
+)
+
+// summary holds the fields available to the --summary template.
+type summary struct {
+	Updated  int64
+	UpToDate int64
+	Duration time.Duration
+}
+
+func parseSummaryTemplate() (*template.Template, error) {
+	tmpl, err := template.New("summary").Parse(*summaryFormat)
+	if err != nil {
+		return nil, err
+	}
+	// Catch references to unknown fields before syncing anything.
+	if err := tmpl.Execute(io.Discard, &summary{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// isTerminal returns whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor returns whether output to stderr may include emoji and ANSI
+// escape sequences.
+func useColor() bool {
+	return os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr)
+}
+
+// printSummary prints the summary line, unless --quiet is set.
+func printSummary(tmpl *template.Template, s *summary) error {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, s); err != nil {
+		return err
+	}
+	line := buf.String()
+	if *quiet {
+		debugf("%s", line)
+		return nil
+	}
+	if useColor() {
+		if s.Updated > 0 {
+			line = "🔄 " + line
+		} else {
+			line = "\x1b[90m" + line + "\x1b[m"
+		}
+	}
+	printf("%s\n", line)
+	return nil
+}
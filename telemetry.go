@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+const (
+	telemetryTimeout = 2 * time.Second
+)
+
+var (
+	telemetryEndpoint = flag.String("telemetry-endpoint", "", "Opt in to reporting anonymous, aggregate usage stats (run duration, proto and file counts, languages, error categories) to this URL. Nothing is reported unless this is set.")
+)
+
+// telemetryReport is the payload sent to --telemetry-endpoint. It must never
+// include paths, proto names, or other repository contents.
+type telemetryReport struct {
+	Command    string           `json:"command"`
+	OS         string           `json:"os"`
+	Arch       string           `json:"arch"`
+	DurationMs int64            `json:"duration_ms"`
+	Workspaces int              `json:"workspaces"`
+	Protos     int64            `json:"protos"`
+	Updated    int64            `json:"updated"`
+	UpToDate   int64            `json:"up_to_date"`
+	Missing    int              `json:"missing"`
+	Languages  map[string]int64 `json:"languages"`
+	Errors     map[string]int   `json:"errors"`
+}
+
+// reportTelemetry sends aggregate stats for the run, if opted in. Failures
+// are only logged; telemetry must never affect the run itself.
+func reportTelemetry(command string, start time.Time, workspaces []*workspaceResult) {
+	if *telemetryEndpoint == "" {
+		return
+	}
+	if command == "" {
+		command = "sync"
+	}
+	report := &telemetryReport{
+		Command:    command,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		DurationMs: time.Since(start).Milliseconds(),
+		Workspaces: len(workspaces),
+		Languages:  map[string]int64{},
+		Errors:     map[string]int{},
+	}
+	for _, ws := range workspaces {
+		if ws.err != nil {
+			report.Errors[ws.errCategory]++
+		}
+		if ws.result == nil {
+			continue
+		}
+		report.Protos += ws.result.protos
+		report.Updated += ws.result.created
+		report.UpToDate += ws.result.upToDate
+		report.Missing += len(ws.result.missing)
+		for kind, n := range ws.result.kinds {
+			report.Languages[kind] += n
+		}
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		debugf("failed to encode telemetry: %s", err)
+		return
+	}
+	client := &http.Client{Timeout: telemetryTimeout}
+	rsp, err := client.Post(*telemetryEndpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		debugf("failed to report telemetry: %s", err)
+		return
+	}
+	rsp.Body.Close()
+	debugf("reported telemetry to %s: %s", *telemetryEndpoint, rsp.Status)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/build"
+)
+
+var (
+	listUnsupported = flag.Bool("list-unsupported", false, "Print the kinds of the rules next to proto_library rules that pbsync doesn't know how to sync (e.g. java_proto_library or custom macros), with counts.")
+)
+
+// maxUnsupportedExamples is the number of rules listed per unsupported kind.
+const maxUnsupportedExamples = 3
+
+// unsupportedRule is a rule related to a proto_library that pbsync doesn't
+// know how to sync.
+type unsupportedRule struct {
+	kind, label string
+}
+
+// protoRuleRefs returns the names of the proto_library rules in the same
+// package (out of protoRuleNames) that r refers to in its proto or deps.
+func protoRuleRefs(r *build.Rule, protoRuleNames map[string]bool) []string {
+	var refs []string
+	for _, dep := range append(r.AttrStrings("deps"), r.AttrString("proto")) {
+		if name := strings.TrimPrefix(dep, ":"); protoRuleNames[name] {
+			refs = append(refs, name)
+		}
+	}
+	return refs
+}
+
+// isProtoAdjacent returns whether the unsupported rule r, which refers to
+// the proto_library rules refs, looks like it generates code from protos.
+func isProtoAdjacent(r *build.Rule, refs []string) bool {
+	return r.Kind() != "proto_library" && (len(refs) > 0 || strings.Contains(r.Kind(), "proto"))
+}
+
+func (r *result) addUnsupported(rules []unsupportedRule) {
+	if len(rules) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.unsupported == nil {
+		r.unsupported = map[string]map[string]bool{}
+	}
+	for _, u := range rules {
+		if r.unsupported[u.kind] == nil {
+			r.unsupported[u.kind] = map[string]bool{}
+		}
+		r.unsupported[u.kind][u.label] = true
+	}
+}
+
+// printUnsupported prints the unsupported rule kinds seen, by number of
+// rules.
+func printUnsupported(unsupported map[string]map[string]bool) {
+	if len(unsupported) == 0 {
+		printf("pbsync: no unsupported rule kinds found\n")
+		return
+	}
+	var kinds []string
+	for kind := range unsupported {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if len(unsupported[kinds[i]]) != len(unsupported[kinds[j]]) {
+			return len(unsupported[kinds[i]]) > len(unsupported[kinds[j]])
+		}
+		return kinds[i] < kinds[j]
+	})
+	printf("pbsync: unsupported rule kinds:\n")
+	for _, kind := range kinds {
+		var labels []string
+		for l := range unsupported[kind] {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		debugf("unsupported %s rules: %s", kind, strings.Join(labels, ", "))
+		if len(labels) > maxUnsupportedExamples {
+			labels = append(labels[:maxUnsupportedExamples:maxUnsupportedExamples], "...")
+		}
+		printf("  %s (%d): %s\n", kind, len(unsupported[kind]), strings.Join(labels, ", "))
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	packageGoDest = "package"
+	vendorGoDest  = "vendor"
+)
+
+var (
+	goDest = flag.String("go-dest", packageGoDest, `Where generated Go files are synced: "package" for the workspace directory matching the importpath, or "vendor" for vendor/<importpath>/ (also adding the packages to vendor/modules.txt).`)
+)
+
+func validateGoDest() error {
+	switch *goDest {
+	case packageGoDest, vendorGoDest:
+		return nil
+	}
+	return fmt.Errorf("invalid --go-dest %q (must be %q or %q)", *goDest, packageGoDest, vendorGoDest)
+}
+
+// goDestDir returns the workspace-relative directory (with forward slashes)
+// that Go files with the given importpath are synced to: the directory of
+// the package in the workspace's Go module providing it (the one whose
+// module path is the longest prefix of importPath), or else the path
+// following the GitHub repo in importPath.
+func goDestDir(workspaceRoot, importPath string) (string, error) {
+	if *goDest == vendorGoDest {
+		return path.Join("vendor", importPath), nil
+	}
+	if dir, ok := goModuleDir(workspaceRoot, importPath); ok {
+		return dir, nil
+	}
+	wsRelpath := githubRepoRe.ReplaceAllLiteralString(importPath, "")
+	if wsRelpath == importPath {
+		return "", fmt.Errorf("could not figure out workspace relative path for import %q", importPath)
+	}
+	return wsRelpath, nil
+}
+
+// updateVendorModules adds the vendored packages that Go files were synced
+// to to vendor/modules.txt, under the vendored module providing them, so
+// that the go command accepts them.
+func updateVendorModules(workspaceRoot string, actions []*syncAction) error {
+	vendorDir := filepath.Join(workspaceRoot, "vendor")
+	pkgs := map[string]bool{}
+	for _, a := range actions {
+		if !strings.HasSuffix(a.dest, ".go") {
+			continue
+		}
+		rel, err := filepath.Rel(vendorDir, filepath.Dir(a.dest))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		pkgs[filepath.ToSlash(rel)] = true
+	}
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	modulesPath := filepath.Join(vendorDir, "modules.txt")
+	b, err := os.ReadFile(fsPath(modulesPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			warnf("%s does not exist; run `go mod vendor` for vendored generated code to be usable", modulesPath)
+			return nil
+		}
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	// Find the packages already listed, and the modules that packages are
+	// listed under (the lines following "# <module> <version>").
+	listed := map[string]bool{}
+	moduleEnd := map[string]int{}
+	module := ""
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			module = strings.Fields(line)[1]
+			moduleEnd[module] = i + 1
+		case strings.HasPrefix(line, "#"):
+			// "## explicit" and similar annotations.
+			if module != "" {
+				moduleEnd[module] = i + 1
+			}
+		case line != "":
+			listed[line] = true
+			if module != "" {
+				moduleEnd[module] = i + 1
+			}
+		}
+	}
+
+	var missing []string
+	for pkg := range pkgs {
+		if !listed[pkg] {
+			missing = append(missing, pkg)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	// Add each package after the last line of its module.
+	inserts := map[int][]string{}
+	for _, pkg := range missing {
+		owner := ""
+		for m := range moduleEnd {
+			if (pkg == m || strings.HasPrefix(pkg, m+"/")) && len(m) > len(owner) {
+				owner = m
+			}
+		}
+		if owner == "" {
+			warnf("%s: no vendored module provides %s; add its module to go.mod and run `go mod vendor`", modulesPath, pkg)
+			continue
+		}
+		inserts[moduleEnd[owner]] = append(inserts[moduleEnd[owner]], pkg)
+	}
+	if len(inserts) == 0 {
+		return nil
+	}
+	var positions []int
+	for pos := range inserts {
+		positions = append(positions, pos)
+	}
+	// Insert in reverse order of position so earlier positions stay valid.
+	sort.Sort(sort.Reverse(sort.IntSlice(positions)))
+	for _, pos := range positions {
+		lines = append(lines[:pos], append(inserts[pos], lines[pos:]...)...)
+	}
+	debugf("%s: adding %d vendored packages", modulesPath, len(missing))
+	return os.WriteFile(fsPath(modulesPath), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateVendorModules(t *testing.T) {
+	const modules = `# example.com/dep v1.0.0
+## explicit; go 1.21
+example.com/dep
+example.com/dep/sub
+# example.com/dep/v2 v2.0.0
+## explicit
+example.com/dep/v2
+# example.com/other v0.1.0
+## explicit
+`
+	for _, tc := range []struct {
+		name string
+		// modules is vendor/modules.txt before the update, or "" if there
+		// is none.
+		modules string
+		dests   []string
+		want    string
+	}{
+		{
+			name:    "no vendored Go files",
+			modules: modules,
+			dests:   []string{"api/api.pb.go", "vendor/example.com/dep/api/api.d.ts"},
+			want:    modules,
+		},
+		{
+			name:    "already listed",
+			modules: modules,
+			dests:   []string{"vendor/example.com/dep/sub/sub.pb.go"},
+			want:    modules,
+		},
+		{
+			name:    "added after the module's last package",
+			modules: modules,
+			dests:   []string{"vendor/example.com/dep/api/api.pb.go", "vendor/example.com/dep/api/api_grpc.pb.go"},
+			want: `# example.com/dep v1.0.0
+## explicit; go 1.21
+example.com/dep
+example.com/dep/sub
+example.com/dep/api
+# example.com/dep/v2 v2.0.0
+## explicit
+example.com/dep/v2
+# example.com/other v0.1.0
+## explicit
+`,
+		},
+		{
+			name:    "longest module wins",
+			modules: modules,
+			dests:   []string{"vendor/example.com/dep/v2/api/api.pb.go"},
+			want: `# example.com/dep v1.0.0
+## explicit; go 1.21
+example.com/dep
+example.com/dep/sub
+# example.com/dep/v2 v2.0.0
+## explicit
+example.com/dep/v2
+example.com/dep/v2/api
+# example.com/other v0.1.0
+## explicit
+`,
+		},
+		{
+			name:    "module without packages",
+			modules: modules,
+			dests:   []string{"vendor/example.com/other/b/b.pb.go", "vendor/example.com/other/a/a.pb.go"},
+			want:    modules + "example.com/other/a\nexample.com/other/b\n",
+		},
+		{
+			name:    "no providing module",
+			modules: modules,
+			dests:   []string{"vendor/example.org/x/x.pb.go"},
+			want:    modules,
+		},
+		{
+			name:  "no modules.txt",
+			dests: []string{"vendor/example.com/dep/api/api.pb.go"},
+			want:  "",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			path := filepath.Join(root, "vendor", "modules.txt")
+			if tc.modules != "" {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tc.modules), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var actions []*syncAction
+			for _, dest := range tc.dests {
+				actions = append(actions, &syncAction{dest: filepath.Join(root, filepath.FromSlash(dest))})
+			}
+			if err := updateVendorModules(root, actions); err != nil {
+				t.Fatalf("updateVendorModules() failed: %s", err)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if got := string(b); got != tc.want {
+				t.Errorf("modules.txt = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	goVerifier = "go"
+	tsVerifier = "ts"
+)
+
+var (
+	verify = flag.String("verify", "", `Comma-separated checks to run after syncing, on the packages whose generated files changed. "go" runs `+"`go vet`"+` on changed Go packages. "ts" type-checks the TypeScript projects (tsconfig.json) containing changed .d.ts files with `+"`tsc --noEmit`"+`.`)
+)
+
+func verifiers() []string {
+	var res []string
+	for _, v := range strings.Split(*verify, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func validateVerify() error {
+	for _, v := range verifiers() {
+		switch v {
+		case goVerifier, tsVerifier:
+		default:
+			return fmt.Errorf("invalid --verify check %q", v)
+		}
+	}
+	return nil
+}
+
+// verifyResult runs the configured checks against the files updated by a
+// sync. The modules and projects containing them are looked up below the
+// directory the files were written to, which is outside of the workspace
+// with an output_root.
+func verifyResult(workspaceRoot string, result *result) error {
+	root := result.destRoot()
+	for _, v := range verifiers() {
+		var err error
+		switch v {
+		case goVerifier:
+			err = verifyGo(root, result.updated)
+		case tsVerifier:
+			err = verifyTS(workspaceRoot, root, result.updated)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyGo vets the Go packages containing any of the updated files, which
+// catches importpath misconfigurations and missing sibling files. The files
+// are in root.
+func verifyGo(root string, updated []string) error {
+	// Group package dirs by the Go module they belong to, since `go vet`
+	// only accepts packages from the main module.
+	modulePkgs := map[string]map[string]bool{}
+	unverified := map[string]bool{}
+	for _, path := range updated {
+		if !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		dir := filepath.Dir(path)
+		modDir := findEnclosingFile(dir, root, "go.mod")
+		if modDir == "" {
+			unverified[dir] = true
+			continue
+		}
+		if modulePkgs[modDir] == nil {
+			modulePkgs[modDir] = map[string]bool{}
+		}
+		rel, err := filepath.Rel(modDir, dir)
+		if err != nil {
+			return err
+		}
+		modulePkgs[modDir]["./"+filepath.ToSlash(rel)] = true
+	}
+	warnUnverified(unverified, "Go packages", "go.mod")
+	for modDir, pkgs := range modulePkgs {
+		args := []string{"vet"}
+		for pkg := range pkgs {
+			args = append(args, pkg)
+		}
+		sort.Strings(args[1:])
+		cmd := exec.Command("go", args...)
+		cmd.Dir = modDir
+		debugf("%s: running go %q", modDir, args)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("go %s: %s\n%s", strings.Join(args, " "), err, b)
+		}
+	}
+	return nil
+}
+
+// verifyTS type-checks the TypeScript projects containing any of the updated
+// declaration files, which are in root.
+func verifyTS(workspaceRoot, root string, updated []string) error {
+	projects := map[string]bool{}
+	unverified := map[string]bool{}
+	for _, path := range updated {
+		if !strings.HasSuffix(path, ".d.ts") {
+			continue
+		}
+		if dir := findEnclosingFile(filepath.Dir(path), root, "tsconfig.json"); dir != "" {
+			projects[dir] = true
+		} else {
+			unverified[filepath.Dir(path)] = true
+		}
+	}
+	warnUnverified(unverified, "TypeScript declarations", "tsconfig.json")
+	if len(projects) == 0 {
+		return nil
+	}
+
+	// Prefer the workspace's own TypeScript version.
+	tsc := filepath.Join(workspaceRoot, "node_modules", ".bin", "tsc")
+	if _, err := os.Stat(fsPath(tsc)); err != nil {
+		tsc = "tsc"
+	}
+	dirs := make([]string, 0, len(projects))
+	for dir := range projects {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		cmd := exec.Command(tsc, "--noEmit", "-p", dir)
+		cmd.Dir = workspaceRoot
+		debugf("%s: running %s --noEmit -p %s", workspaceRoot, tsc, dir)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("tsc --noEmit -p %s: %s\n%s", dir, err, b)
+		}
+	}
+	return nil
+}
+
+// warnUnverified warns about the directories of updated files that --verify
+// couldn't check, since none of them or their parents contains the file
+// name, e.g. "go.mod".
+func warnUnverified(dirs map[string]bool, what, name string) {
+	if len(dirs) == 0 {
+		return
+	}
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	out := &outputBlock{}
+	defer out.flush()
+	out.warnf("--verify: not checking updated %s without an enclosing %s:", what, name)
+	for _, dir := range sorted {
+		out.printf("  %s\n", dir)
+	}
+}
+
+// findEnclosingFile returns the nearest directory, starting at dir and
+// walking up to (and including) root, which contains a file with the given
+// name. It returns "" if there is none.
+func findEnclosingFile(dir, root, name string) string {
+	for {
+		if _, err := os.Stat(fsPath(filepath.Join(dir, name))); err == nil {
+			return dir
+		}
+		if dir == root {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || !strings.HasPrefix(parent, root) {
+			return ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	workspaceFlag = flag.String("workspace", "", "Root of the Bazel workspace to sync. By default, the workspace containing the working directory (or each directory argument) is found by searching upwards for a WORKSPACE or MODULE.bazel file.")
+	recursive     = flag.Bool("recursive", false, "Sync all Bazel workspaces found below each directory argument (or the working directory), e.g. a directory of several repos, instead of the workspace containing it.")
+	jobs          = flag.Int("jobs", 4, "Maximum number of workspaces to sync concurrently.")
+
+	workspaceMarkers = []string{"MODULE.bazel", "WORKSPACE", "WORKSPACE.bazel"}
+)
+
+// isWorkspaceRoot returns whether dir is the root of a Bazel workspace.
+func isWorkspaceRoot(dir string) bool {
+	for _, marker := range workspaceMarkers {
+		if _, err := os.Stat(fsPath(filepath.Join(dir, marker))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// findWorkspaceRoot returns the root of the Bazel workspace containing dir.
+func findWorkspaceRoot(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for d := abs; ; {
+		if isWorkspaceRoot(d) {
+			return d, nil
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+	return "", fmt.Errorf("%q is not inside a Bazel workspace (no WORKSPACE or MODULE.bazel file found in it or any parent directory)", dir)
+}
+
+// findWorkspaceRoots returns the roots of the Bazel workspaces below dir
+// (including dir itself). Workspaces nested in other workspaces (such as
+// examples or test data) and hidden directories are not searched.
+func findWorkspaceRoots(dir string) ([]string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	err = filepath.WalkDir(fsPath(abs), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != fsPath(abs) && os.IsPermission(err) {
+				debugf("skipping unreadable directory %s", path)
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(fsPath(abs), path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules") {
+			return filepath.SkipDir
+		}
+		if root := filepath.Join(abs, rel); isWorkspaceRoot(root) {
+			roots = append(roots, root)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no Bazel workspaces (with a WORKSPACE or MODULE.bazel file) found below %q", dir)
+	}
+	return roots, nil
+}
+
+// workspaceRoots returns the workspaces to sync for the given directory
+// arguments.
+func workspaceRoots(dirs []string) ([]string, error) {
+	var roots []string
+	if *workspaceFlag != "" {
+		root, err := filepath.Abs(*workspaceFlag)
+		if err != nil {
+			return nil, err
+		}
+		if !isWorkspaceRoot(root) {
+			return nil, fmt.Errorf("--workspace %q does not appear to be a Bazel workspace (no WORKSPACE or MODULE.bazel file)", *workspaceFlag)
+		}
+		roots = append(roots, root)
+	}
+	if len(dirs) == 0 && len(roots) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine working dir: %s", err)
+		}
+		dirs = append(dirs, cwd)
+	}
+	for _, dir := range dirs {
+		if *recursive {
+			found, err := findWorkspaceRoots(dir)
+			if err != nil {
+				return nil, err
+			}
+			roots = append(roots, found...)
+			continue
+		}
+		root, err := findWorkspaceRoot(dir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	// Several arguments may be inside the same workspace.
+	var unique []string
+	seen := map[string]bool{}
+	for _, root := range roots {
+		if !seen[root] {
+			seen[root] = true
+			unique = append(unique, root)
+		}
+	}
+	return unique, nil
+}
+
+// workspaceSyncFunc syncs the given protos (or all protos, if protoList is
+// nil) of a workspace.
+type workspaceSyncFunc func(ctx context.Context, workspaceRoot string, protoList []string) (*result, error)
+
+// workspaceResult is the outcome of syncing one workspace.
+type workspaceResult struct {
+	dir    string
+	result *result
+	err    error
+	// errCategory classifies err for telemetry.
+	errCategory string
+}
+
+// syncWorkspaces runs syncFunc (and any verification) on each workspace, up to
+// --jobs at a time. A failure in one workspace does not stop the others.
+func syncWorkspaces(ctx context.Context, dirs, protoList []string, syncFunc workspaceSyncFunc) []*workspaceResult {
+	n := *jobs
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+	results := make([]*workspaceResult, len(dirs))
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		i, dir := i, dir
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ws := &workspaceResult{dir: dir}
+			results[i] = ws
+			if err := ctx.Err(); err != nil {
+				ws.err = err
+				return
+			}
+			ws.result, ws.err = syncFunc(ctx, dir, protoList)
+			if ws.err != nil {
+				ws.err = fmt.Errorf("failed to sync protos: %s", ws.err)
+				ws.errCategory = "sync"
+				return
+			}
+			if err := verifyResult(dir, ws.result); err != nil {
+				ws.err = fmt.Errorf("verification failed: %s", err)
+				ws.errCategory = "verify"
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputRoot returns the directory generated files are written under, in
+// place of the workspace root: the config's output_root (relative to the
+// workspace root), or workspaceRoot if it isn't set.
+func (c *config) outputRoot(workspaceRoot string) (string, error) {
+	if c == nil || c.OutputRoot == "" {
+		return workspaceRoot, nil
+	}
+	dir := c.OutputRoot
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(workspaceRoot, dir)
+	}
+	info, err := os.Stat(fsPath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("output_root %s does not exist (e.g. create it with `git worktree add %s <branch>`)", dir, dir)
+		}
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("output_root %s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// destRoot returns the directory destinations are written under.
+func (r *result) destRoot() string {
+	if r.outputRoot != "" {
+		return r.outputRoot
+	}
+	return r.workspaceRoot
+}
+
+// rebaseActions moves the destinations of the actions from the workspace
+// into the output root.
+func rebaseActions(workspaceRoot, outputRoot string, actions []*syncAction) {
+	if outputRoot == workspaceRoot {
+		return
+	}
+	for _, a := range actions {
+		rel, err := filepath.Rel(workspaceRoot, a.dest)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		a.dest = filepath.Join(outputRoot, rel)
+	}
+}